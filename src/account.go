@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// This file handles the self-service account routes of authenticated users. The /account/password page lets local
+// users change their password. The current password (and the TOTP token if TOTP is enabled) has to be entered, since
+// the TOTP secret is encrypted with the password and has to be re-encrypted with the new password.
+
+// PasswordFormData represents the inputs defined in the password template as a struct.
+type PasswordFormData struct {
+	CurrentPassword      string `json:"inputCurrentPassword"`
+	NewPassword          string `json:"inputNewPassword"`
+	TOTP                 string `json:"inputTotp"`
+	SignOutOtherSessions bool   `json:"signOutOtherSessions"`
+}
+
+// accountPassword handles the GET /account/password route and displays the password form to authenticated users.
+// Unauthenticated users are redirected to the login page, which redirects back after a successful login.
+func accountPassword(c *gin.Context) {
+	cookie, err := verifyRequestCookie(c)
+
+	if err != nil {
+		c.Redirect(http.StatusFound, "/login?callback="+url.QueryEscape(c.Request.URL.RequestURI()))
+		return
+	}
+
+	user, err := store.GetUser(cookie.Username)
+
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		appLog.Printf("error: could not read user with username '%s' from database. %s\n", cookie.Username, err)
+		return
+	}
+
+	// attach all embedded CSS/JS files to the HTML template
+	cssFiles := GetFilenamesFromFS(staticFiles, "css")
+	jsFiles := GetFilenamesFromFS(staticFiles, "js")
+
+	c.HTML(http.StatusOK, "password.html", gin.H{
+		"cssFiles":   cssFiles,
+		"jsFiles":    jsFiles,
+		"username":   cookie.Username,
+		"ldapUser":   user == nil,
+		"otpEnabled": user != nil && len(user.OtpSecret) != 0,
+	})
+}
+
+// processPasswordForm handles the POST /account/password route. The password of the authenticated user is changed
+// after the current password and the TOTP token (if TOTP is enabled) were verified. If requested, all other sessions
+// of the user are signed out and a new session is issued for the current client.
+func processPasswordForm(c *gin.Context) {
+	cookie, err := verifyRequestCookie(c)
+	clientIp := GetClientIpFromContext(c)
+
+	if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	var data PasswordFormData
+
+	if err = c.ShouldBindJSON(&data); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad input"})
+		return
+	}
+
+	username := cookie.Username
+	user, err := store.GetUser(username)
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not read user from database"})
+		appLog.Printf("error: could not read user with username '%s' from database. %s\n", username, err)
+		return
+	} else if user == nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "the password of LDAP users cannot be changed"})
+		return
+	}
+
+	_, err = verifyCredentials(username, data.CurrentPassword, data.TOTP)
+
+	if errors.Is(err, errInvalidTotp) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid TOTP"})
+		authLog.Printf("invalid TOTP on password change of user with username '%s' and client IP '%s'\n", username, clientIp)
+		return
+	} else if errors.Is(err, errInvalidPassword) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid password"})
+		authLog.Printf("invalid current password on password change of user with username '%s' and client IP '%s'\n", username, clientIp)
+		return
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	if err = CheckPasswordRequirements(data.NewPassword); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": strings.TrimPrefix(err.Error(), "error: ")})
+		return
+	}
+
+	if err = ChangeUserPassword(username, data.CurrentPassword, data.NewPassword); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not change password"})
+		appLog.Printf("error: could not change the password of user with username '%s'. %s\n", username, err)
+		return
+	}
+
+	authLog.Printf("user with username '%s' and client IP '%s' changed the password\n", username, clientIp)
+
+	if !data.SignOutOtherSessions {
+		c.JSON(http.StatusOK, gin.H{"expires": cookie.Expires.UnixMilli()})
+		return
+	}
+
+	if err = RevokeUserSessions(username); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "password changed, but could not sign out other sessions"})
+		appLog.Printf("error: could not revoke the sessions of user with username '%s'. %s\n", username, err)
+		return
+	}
+
+	newCookie := createAndSetAuthCookie(c, username, cookie.Domain, cookie.AuthMethod)
+
+	authLog.Printf("user with username '%s' and client IP '%s' signed out all other sessions\n", username, clientIp)
+
+	c.JSON(http.StatusOK, gin.H{"expires": newCookie.Expires.UnixMilli()})
+}
+
+// verifyRequestCookie verifies the session cookie of the given request and returns the corresponding Cookie.
+func verifyRequestCookie(c *gin.Context) (*Cookie, error) {
+	token, err := c.Cookie("Nginx-Auth-Server-Token")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return VerifyCookie(token)
+}
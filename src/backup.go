@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// This file implements the backup and restore of the database and the portable JSON export/import of users and
+// sessions. Backups are binary copies of the bbolt database written within a single read-only transaction, so they
+// are consistent even if the server is running. The JSON export is independent of the storage layout and can be
+// imported by other versions of the application.
+
+const (
+	// exportFormat identifies JSON files written by 'db export'
+	exportFormat = "nginx-auth-server-export"
+
+	// exportVersion is the version of the JSON export format. Increase the version on incompatible changes.
+	exportVersion = 1
+)
+
+// Export is the structure of the JSON export of the database
+type Export struct {
+	Format        string    `json:"format"`
+	Version       int       `json:"version"`       // Version :: version of the export format (see exportVersion)
+	AppVersion    string    `json:"appVersion"`    // AppVersion :: version of the application that wrote the export
+	SchemaVersion int       `json:"schemaVersion"` // SchemaVersion :: database schema version of the application
+	Exported      time.Time `json:"exported"`
+	Users         []User    `json:"users"` // Users :: users including password hashes and encrypted OTP secrets
+	Sessions      []Cookie  `json:"sessions"`
+}
+
+// getBoltStore returns the bbolt database of the store of the application.
+func getBoltStore() (*boltStore, error) {
+	switch s := store.(type) {
+	case *boltStore:
+		return s, nil
+	case *splitStore:
+		return s.base, nil
+	}
+
+	return nil, errors.New("the configured store is not backed by a bbolt database")
+}
+
+// BackupDatabase writes a consistent copy of the database to the given path. If the server is running, the server
+// sends the copy, so it keeps serving requests. Returns the size of the backup in bytes.
+func BackupDatabase(path string) (int64, error) {
+	s, err := getBoltStore()
+
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := s.copyDatabase(file)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(path)
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// RestoreDatabase replaces the contents of the database with the backup at the given path within a single
+// transaction. Backups of older versions are migrated afterwards. Returns an error if the backup was written by
+// a newer version of the application.
+func RestoreDatabase(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	backup, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: databaseLockTimeout})
+
+	if err != nil {
+		return fmt.Errorf("could not open backup at '%s'. %s", path, err)
+	}
+
+	defer func() { _ = backup.Close() }()
+
+	s, err := getBoltStore()
+
+	if err != nil {
+		return err
+	}
+
+	return s.withUncheckedDatabase(false, func(db *bolt.DB) error {
+		err := backup.View(func(source *bolt.Tx) error {
+			version, err := getSchemaVersion(source)
+
+			if err != nil {
+				return err
+			}
+
+			if version > getCurrentSchemaVersion() {
+				return fmt.Errorf("%w (schema version %d, supported version %d)", errDatabaseTooNew, version, getCurrentSchemaVersion())
+			}
+
+			return db.Update(func(target *bolt.Tx) error {
+				var names [][]byte
+
+				err := target.ForEach(func(name []byte, _ *bolt.Bucket) error {
+					names = append(names, append([]byte(nil), name...))
+					return nil
+				})
+
+				if err != nil {
+					return err
+				}
+
+				for _, name := range names {
+					if err = target.DeleteBucket(name); err != nil {
+						return err
+					}
+				}
+
+				return source.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+					targetBucket, err := target.CreateBucket(name)
+
+					if err != nil {
+						return err
+					}
+
+					return copyBucket(targetBucket, bucket)
+				})
+			})
+		})
+
+		if err != nil {
+			return err
+		}
+
+		_, _, err = migrateDatabase(db)
+
+		return err
+	})
+}
+
+// copyBucket copies all keys and nested buckets of the source bucket into the target bucket.
+func copyBucket(target *bolt.Bucket, source *bolt.Bucket) error {
+	return source.ForEach(func(key, value []byte) error {
+		// nested buckets have no value
+		if value == nil {
+			nestedBucket, err := target.CreateBucket(key)
+
+			if err != nil {
+				return err
+			}
+
+			return copyBucket(nestedBucket, source.Bucket(key))
+		}
+
+		return target.Put(key, value)
+	})
+}
+
+// ExportDatabase writes the users and sessions in the versioned JSON export format to the given path.
+// Returns the export.
+func ExportDatabase(path string) (*Export, error) {
+	users, err := store.GetUsers()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := store.GetSessions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	export := Export{
+		Format:        exportFormat,
+		Version:       exportVersion,
+		AppVersion:    AppVersion,
+		SchemaVersion: getCurrentSchemaVersion(),
+		Exported:      time.Now(),
+		Users:         users,
+		Sessions:      sessions,
+	}
+
+	buffer, err := json.MarshalIndent(export, "", "  ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the export contains password hashes and session hashes
+	if err = os.WriteFile(path, buffer, 0600); err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+// ImportDatabase imports the users and sessions of the JSON export at the given path. Existing users and sessions
+// are overwritten. If replace is true, all users and sessions that are not part of the export are deleted.
+// The export is validated first and imported within a single transaction (see Store.Import), so an invalid export
+// does not change the database. Expired sessions are skipped. Returns the number of imported users and sessions.
+func ImportDatabase(path string, replace bool) (int, int, error) {
+	buffer, err := os.ReadFile(path)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	export := Export{}
+
+	if err = json.Unmarshal(buffer, &export); err != nil {
+		return 0, 0, fmt.Errorf("could not parse export at '%s'. %s", path, err)
+	}
+
+	if export.Format != exportFormat {
+		return 0, 0, fmt.Errorf("'%s' is not an export of nginx-auth-server", path)
+	}
+
+	if export.Version > exportVersion {
+		return 0, 0, fmt.Errorf("the export was written by a newer version of nginx-auth-server (export version %d, supported version %d)", export.Version, exportVersion)
+	}
+
+	if err = export.validate(); err != nil {
+		return 0, 0, fmt.Errorf("invalid export at '%s': %s", path, err)
+	}
+
+	var sessions []Cookie
+
+	for _, session := range export.Sessions {
+		if session.Expires.Before(time.Now()) {
+			continue
+		}
+
+		if session.ID == "" {
+			session.ID = GenerateSessionID()
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if err = store.Import(export.Users, sessions, replace); err != nil {
+		return 0, 0, err
+	}
+
+	if replace {
+		PurgeCookieCache()
+	}
+
+	return len(export.Users), len(sessions), nil
+}
+
+// validate returns an error if the export contains users or sessions that cannot be imported.
+func (e *Export) validate() error {
+	usernames := make(map[string]bool)
+
+	for i, user := range e.Users {
+		if user.Username == "" {
+			return fmt.Errorf("user %d has no username", i+1)
+		}
+
+		if user.Password == "" {
+			return fmt.Errorf("user '%s' has no password hash", user.Username)
+		}
+
+		if usernames[user.Username] {
+			return fmt.Errorf("user '%s' is contained more than once", user.Username)
+		}
+
+		usernames[user.Username] = true
+	}
+
+	for i, session := range e.Sessions {
+		if session.Value == "" {
+			return fmt.Errorf("session %d has no value", i+1)
+		}
+
+		if session.Username == "" {
+			return fmt.Errorf("session %d has no username", i+1)
+		}
+
+		if session.Expires.IsZero() {
+			return fmt.Errorf("session %d has no expiry", i+1)
+		}
+	}
+
+	return nil
+}
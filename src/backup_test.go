@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImportDatabaseValidatesExport(t *testing.T) {
+	previousStore := store
+	store = NewMemoryStore()
+
+	t.Cleanup(func() {
+		store = previousStore
+	})
+
+	if err := store.SaveUser(User{Username: "alice", Password: "hash-alice"}); err != nil {
+		t.Fatalf("SaveUser: %s", err)
+	}
+
+	expires := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		users    []User
+		sessions []Cookie
+		valid    bool
+	}{
+		{"user without username", []User{{Username: "bob", Password: "hash-bob"}, {Password: "hash"}}, nil, false},
+		{"user without password", []User{{Username: "bob"}}, nil, false},
+		{"duplicate user", []User{{Username: "bob", Password: "hash-bob"}, {Username: "bob", Password: "hash-bob"}}, nil, false},
+		{"session without value", nil, []Cookie{{Username: "bob", Expires: expires}}, false},
+		{"session without username", nil, []Cookie{{Value: "hash-b1", Expires: expires}}, false},
+		{"session without expiry", nil, []Cookie{{Value: "hash-b1", Username: "bob"}}, false},
+		// replaces alice, so it has to be the last test
+		{"valid", []User{{Username: "bob", Password: "hash-bob"}}, []Cookie{{Value: "hash-b1", Username: "bob", Expires: expires}}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "export.json")
+			buffer, err := json.Marshal(Export{Format: exportFormat, Version: exportVersion, Users: test.users, Sessions: test.sessions})
+
+			if err != nil {
+				t.Fatalf("Marshal: %s", err)
+			}
+
+			if err = os.WriteFile(path, buffer, 0600); err != nil {
+				t.Fatalf("WriteFile: %s", err)
+			}
+
+			_, _, err = ImportDatabase(path, true)
+
+			if test.valid {
+				if err != nil {
+					t.Fatalf("ImportDatabase: %s", err)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("ImportDatabase accepted an invalid export")
+			}
+
+			// an invalid export must not change the database
+			if user, _ := store.GetUser("alice"); user == nil {
+				t.Errorf("ImportDatabase deleted users of an invalid export")
+			}
+		})
+	}
+}
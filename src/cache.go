@@ -1,28 +1,216 @@
 package main
 
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
 // This file handles the caching of authentications. Once a user successfully authenticated, the plaintext cookie
-// value and the corresponding cookie is saved to the cache. This cache persists for the runtime of the application.
+// value and a copy of the corresponding cookie is saved to the session cache. Cache entries expire together with the
+// cookie and the least recently used entries are evicted once the configured cache size is reached.
+// Verified HTTP Basic authentication credentials are cached separately for a configurable time.
 
 var (
-	cache = make(map[string]*Cookie)
+	// sessionCache maps plaintext cookie values to elements of sessionCacheList
+	sessionCache = make(map[string]*list.Element)
+	// sessionCacheByHash maps the argon2 hash of a cookie (the session identity) to elements of sessionCacheList
+	sessionCacheByHash = make(map[string]*list.Element)
+	// sessionCacheList contains the cache entries ordered by last use, the most recently used entry is at the front
+	sessionCacheList  = list.New()
+	sessionCacheMutex sync.Mutex
+
+	basicAuthCache      = make(map[string]basicAuthCacheEntry)
+	basicAuthCacheMutex sync.Mutex
 )
 
-// SaveCookieToCache saves a cookie and the corresponding plaintext cookie value to the cache.
+// sessionCacheEntry is a cached cookie together with the corresponding plaintext cookie value.
+type sessionCacheEntry struct {
+	plainCookieValue string
+	cookie           Cookie
+}
+
+// basicAuthCacheEntry is the cached Identity of verified HTTP Basic authentication credentials.
+type basicAuthCacheEntry struct {
+	identity *Identity
+	expires  time.Time
+}
+
+// SaveCookieToCache saves a copy of a cookie and the corresponding plaintext cookie value to the cache.
 // This dramatically decreases latency for future requests, since the plain cookie value does not need to
 // be matched to the argon2 hash in the database for every request.
 func SaveCookieToCache(cookie *Cookie, plainCookieValue string) {
-	cache[plainCookieValue] = cookie
+	cacheSize := GetCookieCacheSize()
+
+	if cookie == nil || cacheSize <= 0 {
+		return
+	}
+
+	sessionCacheMutex.Lock()
+	defer sessionCacheMutex.Unlock()
+
+	if element, ok := sessionCache[plainCookieValue]; ok {
+		entry := element.Value.(*sessionCacheEntry)
+		entry.cookie = *cookie
+		sessionCacheList.MoveToFront(element)
+
+		return
+	}
+
+	element := sessionCacheList.PushFront(&sessionCacheEntry{
+		plainCookieValue: plainCookieValue,
+		cookie:           *cookie,
+	})
+
+	sessionCache[plainCookieValue] = element
+	sessionCacheByHash[cookie.Value] = element
+
+	// evict the least recently used entries
+	for sessionCacheList.Len() > cacheSize {
+		removeSessionCacheElement(sessionCacheList.Back())
+	}
 }
 
-// GetCookieFromCache returns the cookie corresponding to the given plaintext cookie value.
-// Returns nil if no cookie was found.
+// GetCookieFromCache returns a copy of the cookie corresponding to the given plaintext cookie value.
+// Returns nil if no cookie was found or the cookie is expired.
 func GetCookieFromCache(plainCookieValue string) *Cookie {
-	return cache[plainCookieValue]
+	sessionCacheMutex.Lock()
+
+	element, ok := sessionCache[plainCookieValue]
+
+	if !ok {
+		sessionCacheMutex.Unlock()
+		return nil
+	}
+
+	entry := element.Value.(*sessionCacheEntry)
+
+	if entry.cookie.Expires.Before(time.Now()) {
+		removeSessionCacheElement(element)
+		sessionCacheMutex.Unlock()
+		return nil
+	}
+
+	sessionCacheList.MoveToFront(element)
+
+	cookie := entry.cookie
+
+	sessionCacheMutex.Unlock()
+
+	return &cookie
+}
+
+// UpdateCookieInCache replaces the cached copy of the session of the given cookie, if the session is cached.
+func UpdateCookieInCache(cookie *Cookie) {
+	if cookie == nil {
+		return
+	}
+
+	sessionCacheMutex.Lock()
+	defer sessionCacheMutex.Unlock()
+
+	if element, ok := sessionCacheByHash[cookie.Value]; ok {
+		element.Value.(*sessionCacheEntry).cookie = *cookie
+	}
 }
 
-// DeleteCookieFromCache deletes a existing cookie from the cache.
+// DeleteCookieFromCache deletes the cache entry of the session of the given cookie.
 func DeleteCookieFromCache(cookie *Cookie) {
-	if cookie != nil {
-		delete(cache, cookie.Value)
+	if cookie == nil {
+		return
+	}
+
+	sessionCacheMutex.Lock()
+	defer sessionCacheMutex.Unlock()
+
+	if element, ok := sessionCacheByHash[cookie.Value]; ok {
+		removeSessionCacheElement(element)
+	}
+}
+
+// DeleteCookiesFromCacheByUsername deletes all cache entries of the user with the given username.
+func DeleteCookiesFromCacheByUsername(username string) {
+	sessionCacheMutex.Lock()
+	defer sessionCacheMutex.Unlock()
+
+	for element := sessionCacheList.Front(); element != nil; {
+		next := element.Next()
+
+		if element.Value.(*sessionCacheEntry).cookie.Username == username {
+			removeSessionCacheElement(element)
+		}
+
+		element = next
+	}
+}
+
+// PurgeCookieCache deletes all entries from the session cache.
+func PurgeCookieCache() {
+	sessionCacheMutex.Lock()
+	defer sessionCacheMutex.Unlock()
+
+	sessionCache = make(map[string]*list.Element)
+	sessionCacheByHash = make(map[string]*list.Element)
+	sessionCacheList.Init()
+}
+
+// removeSessionCacheElement removes the given element from the session cache.
+// The caller has to hold sessionCacheMutex.
+func removeSessionCacheElement(element *list.Element) {
+	entry := sessionCacheList.Remove(element).(*sessionCacheEntry)
+
+	delete(sessionCache, entry.plainCookieValue)
+	delete(sessionCacheByHash, entry.cookie.Value)
+}
+
+// PurgeBasicAuthCache deletes all entries from the HTTP Basic authentication cache.
+func PurgeBasicAuthCache() {
+	basicAuthCacheMutex.Lock()
+	defer basicAuthCacheMutex.Unlock()
+
+	basicAuthCache = make(map[string]basicAuthCacheEntry)
+}
+
+// SaveBasicAuthToCache saves the Identity of verified HTTP Basic authentication credentials to the cache.
+// The entry expires after the configured cache TTL. The credentials are only saved as a SHA-256 hash.
+func SaveBasicAuthToCache(username string, password string, identity *Identity) {
+	ttl := time.Duration(GetBasicAuthCacheTTL()) * time.Second
+
+	if ttl <= 0 {
+		return
 	}
+
+	basicAuthCacheMutex.Lock()
+	defer basicAuthCacheMutex.Unlock()
+
+	basicAuthCache[basicAuthCacheKey(username, password)] = basicAuthCacheEntry{
+		identity: identity,
+		expires:  time.Now().Add(ttl),
+	}
+}
+
+// GetBasicAuthFromCache returns the cached Identity of the given HTTP Basic authentication credentials.
+// Returns nil if the credentials were not found or the entry is expired.
+func GetBasicAuthFromCache(username string, password string) *Identity {
+	basicAuthCacheMutex.Lock()
+	defer basicAuthCacheMutex.Unlock()
+
+	key := basicAuthCacheKey(username, password)
+	entry, ok := basicAuthCache[key]
+
+	if !ok {
+		return nil
+	}
+
+	if entry.expires.Before(time.Now()) {
+		delete(basicAuthCache, key)
+		return nil
+	}
+
+	return entry.identity
+}
+
+// basicAuthCacheKey returns the cache key for the given HTTP Basic authentication credentials.
+func basicAuthCacheKey(username string, password string) string {
+	return HashSHA256(username + "\x00" + password)
 }
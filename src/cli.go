@@ -6,10 +6,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/urfave/cli/v2"
 	"golang.org/x/term"
@@ -42,6 +44,7 @@ var app = &cli.App{
 			Name:    "user",
 			Aliases: []string{"u"},
 			Usage:   "options for user management",
+			Before:  setupStore,
 			Subcommands: []*cli.Command{
 				{
 					Name:    "add",
@@ -57,10 +60,29 @@ var app = &cli.App{
 							Name:    "otp",
 							Aliases: []string{"o"},
 						},
+						&cli.StringSliceFlag{
+							Name:    "group",
+							Aliases: []string{"g"},
+							Usage:   "add the user to the given group (can be used multiple times)",
+						},
+						&cli.StringFlag{
+							Name:    "email",
+							Aliases: []string{"e"},
+							Usage:   "email address of the user",
+						},
+						&cli.StringFlag{
+							Name:    "display-name",
+							Aliases: []string{"n"},
+							Usage:   "display name of the user",
+						},
 					},
 					Action: func(cCtx *cli.Context) error {
 						username := cCtx.String("username")
 
+						if err := CheckEmail(cCtx.String("email")); err != nil {
+							return fmt.Errorf("error: %s\n", err)
+						}
+
 						// check if username is alphanumeric
 						re := regexp.MustCompile("^[a-zA-Z0-9_]*$")
 						if !re.MatchString(username) {
@@ -90,7 +112,84 @@ var app = &cli.App{
 							return err
 						}
 
-						addUser(username, password, cCtx.Bool("otp"))
+						addUser(username, password, cCtx.Bool("otp"), cCtx.StringSlice("group"), cCtx.String("email"), cCtx.String("display-name"))
+						return nil
+					},
+				},
+				{
+					Name:    "edit",
+					Aliases: []string{"e"},
+					Usage:   "edit the email address, the display name and the groups of an existing user",
+					Description: "Sessions capture the groups of the user at the time of login, so all sessions of the user are\n" +
+						"revoked if the groups of the user change.",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "username",
+							Aliases:  []string{"u"},
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:    "email",
+							Aliases: []string{"e"},
+							Usage:   "new email address of the user, an empty value removes the email address",
+						},
+						&cli.StringFlag{
+							Name:    "display-name",
+							Aliases: []string{"n"},
+							Usage:   "new display name of the user, an empty value removes the display name",
+						},
+						&cli.StringSliceFlag{
+							Name:    "group",
+							Aliases: []string{"g"},
+							Usage:   "add the user to the given group (can be used multiple times)",
+						},
+						&cli.StringSliceFlag{
+							Name:    "remove-group",
+							Aliases: []string{"G"},
+							Usage:   "remove the user from the given group (can be used multiple times)",
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						username := cCtx.String("username")
+						addGroups := cCtx.StringSlice("group")
+						removeGroups := cCtx.StringSlice("remove-group")
+
+						var email, displayName *string
+
+						if cCtx.IsSet("email") {
+							value := cCtx.String("email")
+							email = &value
+						}
+
+						if cCtx.IsSet("display-name") {
+							value := cCtx.String("display-name")
+							displayName = &value
+						}
+
+						if email == nil && displayName == nil && len(addGroups) == 0 && len(removeGroups) == 0 {
+							return errors.New("error: nothing to change, use --email, --display-name, --group or --remove-group\n")
+						}
+
+						if email != nil || displayName != nil {
+							if err := UpdateUserProfile(username, email, displayName); err != nil {
+								return fmt.Errorf("error: %s\n", err)
+							}
+						}
+
+						if len(addGroups) != 0 || len(removeGroups) != 0 {
+							changed, err := UpdateUserGroups(username, addGroups, removeGroups)
+
+							if err != nil {
+								return fmt.Errorf("error: %s\n", err)
+							}
+
+							if changed {
+								fmt.Printf("groups of user '%s' changed, all sessions of the user have been revoked\n", username)
+							}
+						}
+
+						fmt.Printf("user '%s' updated\n", username)
+
 						return nil
 					},
 				},
@@ -110,12 +209,186 @@ var app = &cli.App{
 						return nil
 					},
 				},
+				{
+					Name:    "passwd",
+					Aliases: []string{"p"},
+					Usage:   "change or reset the password of an existing user",
+					Description: "Without --current, the password is reset by an administrator. The TOTP secret of the user is encrypted\n" +
+						"with the password and cannot be recovered, so TOTP is disabled until the user is enrolled again (--otp).\n" +
+						"With --current, the current password is verified and the TOTP enrollment is kept.",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "username",
+							Aliases:  []string{"u"},
+							Required: true,
+						},
+						&cli.BoolFlag{
+							Name:    "current",
+							Aliases: []string{"c"},
+							Usage:   "ask for the current password and keep the TOTP enrollment of the user",
+						},
+						&cli.BoolFlag{
+							Name:    "otp",
+							Aliases: []string{"o"},
+							Usage:   "enroll the user in TOTP with a new secret key after resetting the password",
+						},
+						&cli.BoolFlag{
+							Name:    "stdin",
+							Aliases: []string{"s"},
+							Usage:   "read the passwords from stdin, one per line (the current password first if --current is set)",
+						},
+						&cli.BoolFlag{
+							Name:    "revoke-sessions",
+							Aliases: []string{"r"},
+							Usage:   "revoke all sessions of the user",
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						username := cCtx.String("username")
+
+						if cCtx.Bool("current") && cCtx.Bool("otp") {
+							return errors.New("error: --otp cannot be combined with --current, the TOTP enrollment is kept\n")
+						}
+
+						if user, err := store.GetUser(username); err != nil {
+							return err
+						} else if user == nil {
+							return fmt.Errorf("error: user with username '%s' does not exist\n", username)
+						}
+
+						var currentPassword, newPassword string
+
+						if cCtx.Bool("stdin") {
+							count := 1
+
+							if cCtx.Bool("current") {
+								count = 2
+							}
+
+							passwords, err := readPasswordsFromStdin(count)
+
+							if err != nil {
+								return fmt.Errorf("error: %s\n", err)
+							}
+
+							currentPassword, newPassword = passwords[0], passwords[count-1]
+
+							if err = CheckPasswordRequirements(newPassword); err != nil {
+								return fmt.Errorf("%s\n", err)
+							}
+						} else {
+							if cCtx.Bool("current") {
+								fmt.Print("Enter current password: ")
+								byteCurrentPassword, err := term.ReadPassword(int(syscall.Stdin))
+								fmt.Print("\n")
+
+								if err != nil {
+									return err
+								}
+
+								currentPassword = string(byteCurrentPassword)
+							}
+
+							var err error
+
+							if newPassword, err = promptPasswordInput(); err != nil {
+								return err
+							}
+						}
+
+						if cCtx.Bool("current") {
+							if err := ChangeUserPassword(username, currentPassword, newPassword); errors.Is(err, errInvalidPassword) {
+								return errors.New("error: the current password is invalid\n")
+							} else if err != nil {
+								return fmt.Errorf("error: %s\n", err)
+							}
+						} else {
+							var otpSecret []byte
+
+							if cCtx.Bool("otp") {
+								otpSecret = enrollTotp(username, newPassword)
+							}
+
+							otpReset, err := ResetUserPassword(username, newPassword, otpSecret)
+
+							if err != nil {
+								return fmt.Errorf("error: %s\n", err)
+							}
+
+							if otpReset && !cCtx.Bool("otp") {
+								fmt.Printf("warning: TOTP of user '%s' has been disabled, enroll the user again using --otp\n", username)
+							}
+						}
+
+						fmt.Printf("password of user '%s' has been changed\n", username)
+
+						if cCtx.Bool("revoke-sessions") {
+							if err := RevokeUserSessions(username); err != nil {
+								return fmt.Errorf("error: could not revoke the sessions of user '%s'. %s\n", username, err)
+							}
+
+							fmt.Printf("all sessions of user '%s' have been revoked\n", username)
+						}
+
+						return nil
+					},
+				},
+				{
+					Name:    "disable",
+					Aliases: []string{"d"},
+					Usage:   "disable an existing user and revoke all sessions of the user",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "username",
+							Aliases:  []string{"u"},
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:    "reason",
+							Aliases: []string{"r"},
+							Usage:   "note why the user was disabled, shown in 'user list'",
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						if err := DisableUser(cCtx.String("username"), cCtx.String("reason")); err != nil {
+							return fmt.Errorf("error: %s\n", err)
+						}
+
+						fmt.Printf("user '%s' has been disabled and all sessions of the user have been revoked\n", cCtx.String("username"))
+
+						return nil
+					},
+				},
+				{
+					Name:  "enable",
+					Usage: "enable a disabled user",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "username",
+							Aliases:  []string{"u"},
+							Required: true,
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						if err := EnableUser(cCtx.String("username")); err != nil {
+							return fmt.Errorf("error: %s\n", err)
+						}
+
+						fmt.Printf("user '%s' has been enabled\n", cCtx.String("username"))
+
+						return nil
+					},
+				},
 				{
 					Name:    "list",
 					Aliases: []string{"l"},
 					Usage:   "list all users",
 					Action: func(cCtx *cli.Context) error {
-						users := GetUsers()
+						users, err := store.GetUsers()
+
+						if err != nil {
+							return err
+						}
 
 						fmt.Printf("the database contains %d users\n", len(users))
 
@@ -134,6 +407,7 @@ var app = &cli.App{
 			Name:    "cookie",
 			Aliases: []string{"c"},
 			Usage:   "options for cookie management",
+			Before:  setupStore,
 			Subcommands: []*cli.Command{
 				{
 					Name:    "list",
@@ -149,19 +423,26 @@ var app = &cli.App{
 					Action: func(cCtx *cli.Context) error {
 						username := cCtx.String("username")
 						var cookies []Cookie
+						var err error
 
 						if username != "" {
-							cookies = GetCookiesByUsername(username)
+							cookies, err = store.GetSessionsByUsername(username)
 						} else {
-							cookies = GetCookies()
+							cookies, err = store.GetSessions()
 						}
 
-						fmt.Printf("the database contains %d cookies\n", len(cookies))
+						if err != nil {
+							return err
+						}
 
-						if len(cookies) != 0 {
-							cookiesJson, _ := json.MarshalIndent(cookies, "", "  ")
+						fmt.Printf("the database contains %d cookies\n", len(cookies))
 
-							fmt.Println(string(cookiesJson))
+						// the argon2 hash of the cookie value is not shown
+						for _, cookie := range cookies {
+							fmt.Printf("id: %s, username: %s, created: %s, last seen: %s, expires: %s, client IP: %s, "+
+								"authentication method: %s, user agent: '%s'\n", orUnknown(cookie.ID), cookie.Username,
+								formatTime(cookie.Created), formatTime(cookie.LastSeen), formatTime(cookie.Expires),
+								orUnknown(cookie.ClientIP), orUnknown(cookie.AuthMethod), cookie.UserAgent)
 						}
 
 						return nil
@@ -193,10 +474,499 @@ var app = &cli.App{
 							return fmt.Errorf("error: could not delete cookies: %s\n", err)
 						}
 
+						// signed sessions are not stored in the database and have to be revoked
+						if username != "" {
+							err = RevokeSignedSessionsByUsername(username)
+						} else {
+							err = RevokeAllSignedSessions()
+						}
+
+						if err != nil {
+							return fmt.Errorf("error: could not revoke signed sessions: %s\n", err)
+						}
+
 						fmt.Printf("deleted all cookies from database\n")
 						return nil
 					},
 				},
+				{
+					Name:    "revoke",
+					Aliases: []string{"r"},
+					Usage:   "remove the cookie with the given session ID",
+					Description: "Signed sessions ('[Cookies] mode = signed') are not stored in the database. If no cookie with the\n" +
+						"given session ID exists in signed mode, the signed session with the given session ID ('sid' claim of\n" +
+						"the token) is revoked.",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "id",
+							Aliases:  []string{"i"},
+							Usage:    "session ID as shown by 'cookie list'",
+							Required: true,
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						id := cCtx.String("id")
+
+						if GetCookieMode() == "signed" {
+							if cookie, err := GetCookieByID(id); err != nil {
+								return fmt.Errorf("error: could not look up cookie: %s\n", err)
+							} else if cookie == nil {
+								if err = RevokeSignedSessionByID(id); err != nil {
+									return fmt.Errorf("error: could not revoke signed session: %s\n", err)
+								}
+
+								fmt.Printf("revoked signed session with ID '%s'\n", id)
+								return nil
+							}
+						}
+
+						cookie, err := RevokeCookieByID(id)
+
+						if err != nil {
+							return fmt.Errorf("error: could not revoke cookie: %s\n", err)
+						}
+
+						fmt.Printf("deleted cookie with ID '%s' of user with username '%s' from database\n", cookie.ID, cookie.Username)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:    "key",
+			Aliases: []string{"k"},
+			Usage:   "options for the signing keys of signed sessions ('[Cookies] mode = signed')",
+			Before:  setupStore,
+			Subcommands: []*cli.Command{
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "list all signing keys",
+					Action: func(cCtx *cli.Context) error {
+						keys, err := GetSigningKeys()
+
+						if err != nil {
+							return err
+						}
+
+						fmt.Printf("the database contains %d signing keys\n", len(keys))
+
+						for _, key := range keys {
+							status := "current"
+
+							if !key.Retired.IsZero() {
+								status = fmt.Sprintf("retired %s, accepted until %s", key.Retired.Format(time.RFC3339), key.GraceUntil.Format(time.RFC3339))
+							}
+
+							fmt.Printf("id: %s, created: %s, status: %s\n", key.ID, key.Created.Format(time.RFC3339), status)
+						}
+
+						return nil
+					},
+				},
+				{
+					Name:    "rotate",
+					Aliases: []string{"r"},
+					Usage:   "retire the current signing key and create a new one",
+					Flags: []cli.Flag{
+						&cli.IntFlag{
+							Name:    "grace",
+							Aliases: []string{"g"},
+							Usage:   "grace period in hours in which sessions signed with the retired key are still accepted and re-signed",
+							Value:   24,
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						if cCtx.Int("grace") < 0 {
+							return fmt.Errorf("error: grace period must not be negative\n")
+						}
+
+						key, err := RotateSigningKey(time.Duration(cCtx.Int("grace")) * time.Hour)
+
+						if err != nil {
+							return fmt.Errorf("error: could not rotate signing key: %s\n", err)
+						}
+
+						fmt.Printf("created new signing key with ID '%s'\n", key.ID)
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:    "token",
+			Aliases: []string{"t"},
+			Usage:   "options for personal API token management",
+			Before:  setupStore,
+			Subcommands: []*cli.Command{
+				{
+					Name:    "create",
+					Aliases: []string{"c"},
+					Usage:   "create a new API token for an existing user",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "username",
+							Aliases:  []string{"u"},
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:    "description",
+							Aliases: []string{"d"},
+							Usage:   "description of the token, e.g. 'CI pipeline'",
+						},
+						&cli.IntFlag{
+							Name:    "expires",
+							Aliases: []string{"e"},
+							Usage:   "token lifetime in days (0: the token does not expire)",
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						if cCtx.Int("expires") < 0 {
+							return fmt.Errorf("error: token lifetime must not be negative\n")
+						}
+
+						lifetime := time.Duration(cCtx.Int("expires")) * 24 * time.Hour
+
+						plainToken, token, err := CreateAPIToken(cCtx.String("username"), cCtx.String("description"), lifetime)
+
+						if err != nil {
+							return fmt.Errorf("error: could not create API token: %s\n", err)
+						}
+
+						fmt.Printf("API token with ID '%s' for user '%s' created. This token is only shown once:\n", token.ID, token.Username)
+						fmt.Println(plainToken)
+
+						return nil
+					},
+				},
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "list all API tokens",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "username",
+							Aliases: []string{"u"},
+							Usage:   "filter API tokens by username",
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						username := cCtx.String("username")
+						var tokens []APIToken
+						var err error
+
+						if username != "" {
+							tokens, err = GetAPITokensByUsername(username)
+						} else {
+							tokens, err = GetAPITokens()
+						}
+
+						if err != nil {
+							return err
+						}
+
+						fmt.Printf("the database contains %d API tokens\n", len(tokens))
+
+						for _, token := range tokens {
+							expires := "never"
+
+							if !token.Expires.IsZero() {
+								expires = token.Expires.Format(time.RFC3339)
+							}
+
+							if token.IsExpired() {
+								expires += " (expired)"
+							}
+
+							fmt.Printf("id: %s, username: %s, description: '%s', created: %s, expires: %s\n",
+								token.ID, token.Username, token.Description, token.Created.Format(time.RFC3339), expires)
+						}
+
+						return nil
+					},
+				},
+				{
+					Name:    "revoke",
+					Aliases: []string{"r"},
+					Usage:   "revoke an API token",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "id",
+							Required: true,
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						if err := DeleteAPIToken(cCtx.String("id")); err != nil {
+							return fmt.Errorf("error: could not revoke API token: %s\n", err)
+						}
+
+						fmt.Printf("API token with ID '%s' has been revoked\n", cCtx.String("id"))
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:   "db",
+			Usage:  "options for the database",
+			Before: setupStore,
+			Subcommands: []*cli.Command{
+				{
+					Name:    "migrate",
+					Aliases: []string{"m"},
+					Usage:   "migrate the database schema to the version of this application",
+					Action: func(cCtx *cli.Context) error {
+						from, to, err := MigrateDatabase()
+
+						if err != nil {
+							return fmt.Errorf("error: could not migrate database: %s\n", err)
+						}
+
+						if from == to {
+							fmt.Printf("the database schema is up to date (version %d)\n", to)
+						} else {
+							fmt.Printf("migrated the database schema from version %d to version %d\n", from, to)
+						}
+
+						return nil
+					},
+				},
+				{
+					Name:    "backup",
+					Aliases: []string{"b"},
+					Usage:   "write a consistent copy of the database to a file, safe while the server is running",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "out",
+							Aliases:  []string{"o"},
+							Usage:    "path of the backup file",
+							Required: true,
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						size, err := BackupDatabase(cCtx.String("out"))
+
+						if err != nil {
+							return fmt.Errorf("error: could not back up database: %s\n", err)
+						}
+
+						fmt.Printf("database has been backed up to '%s' (%d bytes)\n", cCtx.String("out"), size)
+						return nil
+					},
+				},
+				{
+					Name:  "restore",
+					Usage: "replace the contents of the database with a backup",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "in",
+							Aliases:  []string{"i"},
+							Usage:    "path of the backup file",
+							Required: true,
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						if err := RestoreDatabase(cCtx.String("in")); err != nil {
+							return fmt.Errorf("error: could not restore database: %s\n", err)
+						}
+
+						fmt.Printf("database has been restored from '%s'\n", cCtx.String("in"))
+						return nil
+					},
+				},
+				{
+					Name:    "export",
+					Aliases: []string{"e"},
+					Usage:   "export users (including password hashes and OTP secrets) and sessions to a JSON file",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "out",
+							Aliases:  []string{"o"},
+							Usage:    "path of the JSON file",
+							Required: true,
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						export, err := ExportDatabase(cCtx.String("out"))
+
+						if err != nil {
+							return fmt.Errorf("error: could not export database: %s\n", err)
+						}
+
+						fmt.Printf("exported %d users and %d sessions to '%s'\n", len(export.Users), len(export.Sessions), cCtx.String("out"))
+						return nil
+					},
+				},
+				{
+					Name:    "import",
+					Aliases: []string{"i"},
+					Usage:   "import users and sessions from a JSON file written by 'db export'",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "in",
+							Aliases:  []string{"i"},
+							Usage:    "path of the JSON file",
+							Required: true,
+						},
+						&cli.BoolFlag{
+							Name:  "replace",
+							Usage: "delete all users and sessions before the import",
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						users, sessions, err := ImportDatabase(cCtx.String("in"), cCtx.Bool("replace"))
+
+						if err != nil {
+							return fmt.Errorf("error: could not import database: %s\n", err)
+						}
+
+						fmt.Printf("imported %d users and %d sessions from '%s'\n", users, sessions, cCtx.String("in"))
+						return nil
+					},
+				},
+				{
+					Name:  "keygen",
+					Usage: "generate a new encryption key for the key file (see section [Encryption] in config.ini)",
+					Action: func(cCtx *cli.Context) error {
+						key, err := GenerateMasterKey()
+
+						if err != nil {
+							return fmt.Errorf("error: could not generate encryption key: %s\n", err)
+						}
+
+						fmt.Println(key)
+						return nil
+					},
+				},
+				{
+					Name:  "rekey",
+					Usage: "re-encrypt users and sessions with the current encryption key in a single transaction",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "decrypt",
+							Usage: "decrypt users and sessions instead, to disable the encryption at rest",
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						count, err := RekeyDatabase(cCtx.Bool("decrypt"))
+
+						if err != nil {
+							return fmt.Errorf("error: could not re-encrypt database: %s\n", err)
+						}
+
+						if cCtx.Bool("decrypt") {
+							fmt.Printf("decrypted %d values\n", count)
+						} else {
+							fmt.Printf("re-encrypted %d values\n", count)
+						}
+
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:   "rule",
+			Usage:  "options for authorization rules",
+			Before: setupStore,
+			Subcommands: []*cli.Command{
+				{
+					Name:    "list",
+					Aliases: []string{"l"},
+					Usage:   "list all authorization rules in the order of evaluation",
+					Action: func(cCtx *cli.Context) error {
+						rules := GetRules()
+
+						fmt.Printf("the configuration contains %d rules (default policy: '%s')\n", len(rules), GetAuthorizationDefaultPolicy())
+
+						if len(rules) != 0 {
+							rulesJson, _ := json.MarshalIndent(rules, "", "  ")
+
+							fmt.Println(string(rulesJson))
+						}
+
+						return nil
+					},
+				},
+				{
+					Name:    "explain",
+					Aliases: []string{"e"},
+					Usage:   "explain the authorization decision for the given user or session and URL",
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "username",
+							Aliases: []string{"u"},
+							Usage:   "evaluate the rules with the current groups of the user, i.e. for a fresh login",
+						},
+						&cli.StringFlag{
+							Name:  "session",
+							Usage: "evaluate the rules with the groups of the session with the given ID, like /auth does",
+						},
+						&cli.StringFlag{
+							Name:     "url",
+							Usage:    "URL of the original request, e.g. 'https://example.org/admin'",
+							Required: true,
+						},
+					},
+					Action: func(cCtx *cli.Context) error {
+						username := cCtx.String("username")
+						sessionId := cCtx.String("session")
+
+						if (username == "") == (sessionId == "") {
+							return fmt.Errorf("error: either --username or --session has to be provided\n")
+						}
+
+						requestUrl, err := url.Parse(cCtx.String("url"))
+
+						if err != nil || requestUrl.Hostname() == "" {
+							return fmt.Errorf("error: invalid URL '%s'\n", cCtx.String("url"))
+						}
+
+						path := CleanRequestPath(requestUrl.Path)
+
+						var groups []string
+
+						if sessionId != "" {
+							// /auth authorizes with the groups that were captured at the login of the session
+							cookie, err := GetCookieByID(sessionId)
+
+							if err != nil {
+								return fmt.Errorf("error: could not look up session: %s\n", err)
+							} else if cookie == nil {
+								return fmt.Errorf("error: session with ID '%s' does not exist\n", sessionId)
+							}
+
+							username = cookie.Username
+							groups = cookie.Groups
+
+							fmt.Printf("session: '%s'\n", sessionId)
+						} else {
+							groups = GetUserGroups(username)
+
+							fmt.Println("session: none (current groups of the user, i.e. for a fresh login)")
+						}
+
+						decision := EvaluateRules(username, groups, requestUrl.Hostname(), path)
+
+						fmt.Printf("user: '%s'\n", username)
+						fmt.Printf("groups: %s\n", strings.Join(groups, ", "))
+						fmt.Printf("host: '%s', path: '%s'\n", requestUrl.Hostname(), path)
+
+						if decision.Rule != nil {
+							fmt.Printf("matched rule: '%s'\n", decision.Rule.Name)
+						} else {
+							fmt.Println("matched rule: none")
+						}
+
+						if decision.Allowed {
+							fmt.Printf("decision: allow (%s)\n", decision.Reason)
+						} else {
+							fmt.Printf("decision: deny (%s)\n", decision.Reason)
+						}
+
+						return nil
+					},
+				},
 			},
 		},
 	},
@@ -262,3 +1032,47 @@ func promptPasswordInput() (string, error) {
 		return strings.TrimSpace(password), nil
 	}
 }
+
+// readPasswordsFromStdin reads the given number of passwords from stdin, one password per line.
+func readPasswordsFromStdin(count int) ([]string, error) {
+	scanner := bufio.NewScanner(os.Stdin)
+	passwords := make([]string, 0, count)
+
+	for len(passwords) < count && scanner.Scan() {
+		passwords = append(passwords, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(passwords) < count {
+		return nil, fmt.Errorf("expected %d passwords on stdin, one per line", count)
+	}
+
+	return passwords, nil
+}
+
+// formatTime formats the given time using RFC 3339. Returns "unknown" for the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// orUnknown returns the given value or "unknown" if the value is empty.
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+
+	return value
+}
+
+// setupStore sets up the store of CLI commands that access users or sessions, which may be stored in Redis.
+func setupStore(cCtx *cli.Context) error {
+	store = newStore(&boltStore{})
+	return nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"net"
+	"strings"
 
 	"gopkg.in/ini.v1"
 )
@@ -26,8 +28,17 @@ type TLS struct {
 
 // Cookies :: [Cookies]-Section of .ini
 type Cookies struct {
-	Lifetime int  `ini:"lifetime"`
-	Secure   bool `ini:"secure"`
+	Lifetime          int    `ini:"lifetime"`
+	Secure            bool   `ini:"secure"`
+	SlidingExpiration bool   `ini:"sliding_expiration"`
+	RenewalThreshold  int    `ini:"renewal_threshold"`
+	MaxLifetime       int    `ini:"max_lifetime"`
+	IdleTimeout       int    `ini:"idle_timeout"`
+	Mode              string `ini:"mode"`
+	CacheSize         int    `ini:"cache_size"`
+	ReaperInterval    int    `ini:"reaper_interval"`
+	MaxSessions       int    `ini:"max_sessions"`
+	MaxSessionsPolicy string `ini:"max_sessions_policy"`
 }
 
 // LDAP :: [LDAP]-Section of .ini
@@ -45,12 +56,88 @@ type Recaptcha struct {
 	SecretKey string `ini:"secret_key"`
 }
 
+// Authorization :: [Authorization]-Section of .ini
+type Authorization struct {
+	DefaultPolicy string `ini:"default_policy"`
+	AdminGroup    string `ini:"admin_group"`
+}
+
+// Headers :: [Headers]-Section of .ini
+type Headers struct {
+	Enabled        bool   `ini:"enabled"`
+	User           string `ini:"user"`
+	Groups         string `ini:"groups"`
+	Email          string `ini:"email"`
+	SessionExpires string `ini:"session_expires"`
+}
+
+// BasicAuth :: [BasicAuth]-Section of .ini
+type BasicAuth struct {
+	Enabled  bool   `ini:"enabled"`
+	Realm    string `ini:"realm"`
+	CacheTTL int    `ini:"cache_ttl"`
+}
+
+// ForwardAuth :: [ForwardAuth]-Section of .ini
+type ForwardAuth struct {
+	Enabled        bool     `ini:"enabled"`
+	LoginURL       string   `ini:"login_url"`
+	TrustedProxies []string `ini:"trusted_proxies"`
+
+	trustedProxyNets []*net.IPNet
+}
+
+// SSO :: [SSO]-Section of .ini
+type SSO struct {
+	Enabled      bool     `ini:"enabled"`
+	PrimaryURL   string   `ini:"primary_url"`
+	Domains      []string `ini:"domains"`
+	CodeLifetime int      `ini:"code_lifetime"`
+}
+
+// OIDC :: [OIDC]-Section of .ini
+type OIDC struct {
+	Enabled        bool   `ini:"enabled"`
+	Issuer         string `ini:"issuer"`
+	CodeLifetime   int    `ini:"code_lifetime"`
+	TokenLifetime  int    `ini:"token_lifetime"`
+	SigningKeyFile string `ini:"signing_key_file"`
+}
+
+// Redis :: [Redis]-Section of .ini
+type Redis struct {
+	Enabled   bool   `ini:"enabled"`
+	Address   string `ini:"address"`
+	Password  string `ini:"password"`
+	Database  int    `ini:"database"`
+	KeyPrefix string `ini:"key_prefix"`
+	Timeout   int    `ini:"timeout"`
+	Users     bool   `ini:"users"`
+}
+
+// Encryption :: [Encryption]-Section of .ini
+type Encryption struct {
+	KeyFile string `ini:"key_file"`
+}
+
 type Config struct {
 	Server
 	TLS
 	Cookies
 	LDAP
 	Recaptcha
+	Authorization
+	Headers
+	BasicAuth
+	ForwardAuth
+	SSO
+	OIDC
+	Redis
+	Encryption
+	Rules       []Rule       `ini:"-"`
+	OIDCClients []OIDCClient `ini:"-"`
+	// SessionLimits maps usernames to their maximum number of active sessions ([SessionLimits]-Section of .ini)
+	SessionLimits map[string]int `ini:"-"`
 }
 
 var (
@@ -71,8 +158,17 @@ var (
 			KeyPath:    "",
 		},
 		Cookies: Cookies{
-			Lifetime: 7,
-			Secure:   true,
+			Lifetime:          7,
+			Secure:            true,
+			SlidingExpiration: false,
+			RenewalThreshold:  24,
+			MaxLifetime:       30,
+			IdleTimeout:       0,
+			CacheSize:         10000,
+			ReaperInterval:    60,
+			MaxSessions:       0,
+			MaxSessionsPolicy: "evict",
+			Mode:              "database",
 		},
 		LDAP: LDAP{
 			Enabled:            false,
@@ -85,11 +181,65 @@ var (
 			SiteKey:   "",
 			SecretKey: "",
 		},
+		Authorization: Authorization{
+			DefaultPolicy: "allow",
+		},
+		Headers: Headers{
+			Enabled:        false,
+			User:           "X-Auth-User",
+			Groups:         "X-Auth-Groups",
+			Email:          "X-Auth-Email",
+			SessionExpires: "X-Auth-Session-Expires",
+		},
+		BasicAuth: BasicAuth{
+			Enabled:  false,
+			Realm:    "nginx-auth-server",
+			CacheTTL: 300,
+		},
+		ForwardAuth: ForwardAuth{
+			Enabled:        false,
+			LoginURL:       "",
+			TrustedProxies: nil,
+		},
+		SSO: SSO{
+			Enabled:      false,
+			PrimaryURL:   "",
+			Domains:      nil,
+			CodeLifetime: 60,
+		},
+		OIDC: OIDC{
+			Enabled:        false,
+			Issuer:         "",
+			CodeLifetime:   60,
+			TokenLifetime:  3600,
+			SigningKeyFile: "",
+		},
+		Redis: Redis{
+			Enabled:   false,
+			Address:   "localhost:6379",
+			Password:  "",
+			Database:  0,
+			KeyPrefix: "nginx-auth-server:",
+			Timeout:   5,
+			Users:     false,
+		},
+		Encryption: Encryption{
+			KeyFile: "",
+		},
 	}
 )
 
 const (
 	configFileName = "config.ini"
+
+	// ruleSectionPrefix defines the prefix of the .ini sections that contain authorization rules
+	ruleSectionPrefix = "Rule."
+
+	// oidcClientSectionPrefix defines the prefix of the .ini sections that contain OIDC clients
+	oidcClientSectionPrefix = "OIDCClient."
+
+	// sessionLimitsSectionName defines the name of the .ini section that contains per-user session limits
+	sessionLimitsSectionName = "SessionLimits"
 )
 
 func parse() {
@@ -111,6 +261,100 @@ func parse() {
 		appLog.Fatalf("fatal error while pasing configuration to types: %s", err)
 	}
 
+	if policy := config.Authorization.DefaultPolicy; policy != "allow" && policy != "deny" {
+		appLog.Fatalf("fatal error: invalid default_policy '%s' in section [Authorization], use 'allow' or 'deny'", policy)
+	}
+
+	if mode := config.Cookies.Mode; mode != "database" && mode != "signed" {
+		appLog.Fatalf("fatal error: invalid mode '%s' in section [Cookies], use 'database' or 'signed'", mode)
+	}
+
+	if policy := config.Cookies.MaxSessionsPolicy; policy != "reject" && policy != "evict" {
+		appLog.Fatalf("fatal error: invalid max_sessions_policy '%s' in section [Cookies], use 'reject' or 'evict'", policy)
+	}
+
+	if config.SSO.Enabled && config.SSO.PrimaryURL == "" {
+		appLog.Fatalf("fatal error: SSO is enabled, but no primary_url is configured in section [SSO]")
+	}
+
+	if config.OIDC.Enabled && config.OIDC.Issuer == "" {
+		appLog.Fatalf("fatal error: OIDC is enabled, but no issuer is configured in section [OIDC]")
+	}
+
+	if config.Redis.Enabled && config.Redis.Address == "" {
+		appLog.Fatalf("fatal error: Redis is enabled, but no address is configured in section [Redis]")
+	}
+
+	config.OIDC.Issuer = strings.TrimSuffix(config.OIDC.Issuer, "/")
+
+	// trusted proxies are given as single IP addresses or CIDR ranges
+	for _, proxy := range config.ForwardAuth.TrustedProxies {
+		proxy = strings.TrimSpace(proxy)
+
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		_, proxyNet, err := net.ParseCIDR(proxy)
+
+		if err != nil {
+			appLog.Fatalf("fatal error: invalid trusted proxy '%s' in section [ForwardAuth]: %s", proxy, err)
+		}
+
+		config.ForwardAuth.trustedProxyNets = append(config.ForwardAuth.trustedProxyNets, proxyNet)
+	}
+
+	// map all [Rule.<name>] sections to rules, preserving the order of definition
+	for _, section := range file.Sections() {
+		if !strings.HasPrefix(section.Name(), ruleSectionPrefix) {
+			continue
+		}
+
+		rule := Rule{Name: strings.TrimPrefix(section.Name(), ruleSectionPrefix)}
+
+		if err = section.MapTo(&rule); err != nil {
+			appLog.Fatalf("fatal error while parsing rule '%s': %s", rule.Name, err)
+		}
+
+		if err = rule.compile(); err != nil {
+			appLog.Fatalf("fatal error while parsing rule '%s': %s", rule.Name, err)
+		}
+
+		config.Rules = append(config.Rules, rule)
+	}
+
+	// map all [OIDCClient.<client_id>] sections to OIDC clients
+	for _, section := range file.Sections() {
+		if !strings.HasPrefix(section.Name(), oidcClientSectionPrefix) {
+			continue
+		}
+
+		client := OIDCClient{ID: strings.TrimPrefix(section.Name(), oidcClientSectionPrefix)}
+
+		if err = section.MapTo(&client); err != nil {
+			appLog.Fatalf("fatal error while parsing OIDC client '%s': %s", client.ID, err)
+		}
+
+		config.OIDCClients = append(config.OIDCClients, client)
+	}
+
+	// map the [SessionLimits] section (username = limit) to per-user session limits
+	config.SessionLimits = make(map[string]int)
+
+	for _, key := range file.Section(sessionLimitsSectionName).Keys() {
+		limit, err := key.Int()
+
+		if err != nil {
+			appLog.Fatalf("fatal error while parsing session limit of user '%s' in section [%s]: %s", key.Name(), sessionLimitsSectionName, err)
+		}
+
+		config.SessionLimits[key.Name()] = limit
+	}
+
 	parsed = true
 }
 
@@ -162,6 +406,58 @@ func GetCookieSecure() bool {
 	return config.Cookies.Secure
 }
 
+func GetCookieSlidingExpiration() bool {
+	parse()
+	return config.Cookies.SlidingExpiration
+}
+
+func GetCookieRenewalThreshold() int {
+	parse()
+	return config.Cookies.RenewalThreshold
+}
+
+func GetCookieMaxLifetime() int {
+	parse()
+	return config.Cookies.MaxLifetime
+}
+
+func GetCookieIdleTimeout() int {
+	parse()
+	return config.Cookies.IdleTimeout
+}
+
+func GetCookieMode() string {
+	parse()
+	return config.Cookies.Mode
+}
+
+func GetCookieCacheSize() int {
+	parse()
+	return config.Cookies.CacheSize
+}
+
+func GetCookieReaperInterval() int {
+	parse()
+	return config.Cookies.ReaperInterval
+}
+
+// GetCookieMaxSessions returns the maximum number of active sessions of the user with the given username.
+// The limit in section [SessionLimits] takes precedence over the global limit. Returns 0 for unlimited sessions.
+func GetCookieMaxSessions(username string) int {
+	parse()
+
+	if limit, ok := config.SessionLimits[username]; ok {
+		return limit
+	}
+
+	return config.Cookies.MaxSessions
+}
+
+func GetCookieMaxSessionsPolicy() string {
+	parse()
+	return config.Cookies.MaxSessionsPolicy
+}
+
 func GetLDAPEnabled() bool {
 	parse()
 	return config.LDAP.Enabled
@@ -196,3 +492,164 @@ func GetRecaptchaSecretKey() string {
 	parse()
 	return config.Recaptcha.SecretKey
 }
+
+func GetAuthorizationDefaultPolicy() string {
+	parse()
+	return config.Authorization.DefaultPolicy
+}
+
+func GetAuthorizationAdminGroup() string {
+	parse()
+	return config.Authorization.AdminGroup
+}
+
+func GetRules() []Rule {
+	parse()
+	return config.Rules
+}
+
+func GetHeadersEnabled() bool {
+	parse()
+	return config.Headers.Enabled
+}
+
+func GetHeadersUser() string {
+	parse()
+	return config.Headers.User
+}
+
+func GetHeadersGroups() string {
+	parse()
+	return config.Headers.Groups
+}
+
+func GetHeadersEmail() string {
+	parse()
+	return config.Headers.Email
+}
+
+func GetHeadersSessionExpires() string {
+	parse()
+	return config.Headers.SessionExpires
+}
+
+func GetBasicAuthEnabled() bool {
+	parse()
+	return config.BasicAuth.Enabled
+}
+
+func GetBasicAuthRealm() string {
+	parse()
+	return config.BasicAuth.Realm
+}
+
+func GetBasicAuthCacheTTL() int {
+	parse()
+	return config.BasicAuth.CacheTTL
+}
+
+func GetForwardAuthEnabled() bool {
+	parse()
+	return config.ForwardAuth.Enabled
+}
+
+// GetForwardAuthTrustedProxies returns the parsed IP ranges of the trusted proxies in section [ForwardAuth].
+func GetForwardAuthTrustedProxies() []*net.IPNet {
+	parse()
+	return config.ForwardAuth.trustedProxyNets
+}
+
+func GetForwardAuthLoginUrl() string {
+	parse()
+	return config.ForwardAuth.LoginURL
+}
+
+func GetSSOEnabled() bool {
+	parse()
+	return config.SSO.Enabled
+}
+
+func GetSSOPrimaryUrl() string {
+	parse()
+	return config.SSO.PrimaryURL
+}
+
+func GetSSODomains() []string {
+	parse()
+	return config.SSO.Domains
+}
+
+func GetSSOCodeLifetime() int {
+	parse()
+	return config.SSO.CodeLifetime
+}
+
+func GetOIDCEnabled() bool {
+	parse()
+	return config.OIDC.Enabled
+}
+
+func GetOIDCIssuer() string {
+	parse()
+	return config.OIDC.Issuer
+}
+
+func GetOIDCCodeLifetime() int {
+	parse()
+	return config.OIDC.CodeLifetime
+}
+
+func GetOIDCTokenLifetime() int {
+	parse()
+	return config.OIDC.TokenLifetime
+}
+
+func GetOIDCSigningKeyFile() string {
+	parse()
+	return config.OIDC.SigningKeyFile
+}
+
+func GetOIDCClients() []OIDCClient {
+	parse()
+	return config.OIDCClients
+}
+
+func GetRedisEnabled() bool {
+	parse()
+	return config.Redis.Enabled
+}
+
+func GetRedisAddress() string {
+	parse()
+	return config.Redis.Address
+}
+
+func GetRedisPassword() string {
+	parse()
+	return config.Redis.Password
+}
+
+func GetRedisDatabase() int {
+	parse()
+	return config.Redis.Database
+}
+
+func GetRedisKeyPrefix() string {
+	parse()
+	return config.Redis.KeyPrefix
+}
+
+func GetRedisTimeout() int {
+	parse()
+	return config.Redis.Timeout
+}
+
+func GetRedisUsers() bool {
+	parse()
+	return config.Redis.Users
+}
+
+func GetEncryptionKeyFile() string {
+	parse()
+	return config.Encryption.KeyFile
+}
@@ -1,180 +1,260 @@
 package main
 
 import (
-	"encoding/json"
+	"encoding/hex"
 	"errors"
-	bolt "go.etcd.io/bbolt"
 	"regexp"
+	"sort"
 	"time"
 )
 
 // Cookie :: refer to https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Set-Cookie
 type Cookie struct {
-	Name     string    `json:"name"`
-	Value    string    `json:"value"`   // Value :: argon2 hash
-	Expires  time.Time `json:"expires"` // example: 'Wed, 21 Oct 2015 07:28:00 GMT'
-	Domain   string    `json:"domain"`
-	Username string    `json:"username"`
-	HttpOnly bool      `json:"httpOnly"`
-	Secure   bool      `json:"secure"`
+	ID         string    `json:"id"` // ID :: stable session ID
+	Name       string    `json:"name"`
+	Value      string    `json:"value"`   // Value :: argon2 hash
+	Expires    time.Time `json:"expires"` // example: 'Wed, 21 Oct 2015 07:28:00 GMT'
+	Created    time.Time `json:"created"`
+	LastSeen   time.Time `json:"lastSeen"` // LastSeen :: last activity, persisted at most every lastSeenWriteInterval
+	Domain     string    `json:"domain"`
+	Username   string    `json:"username"`
+	Groups     []string  `json:"groups"`     // Groups :: groups of the user at the time of login
+	Email      string    `json:"email"`      // Email :: email address of the user at the time of login
+	ClientIP   string    `json:"clientIp"`   // ClientIP :: client IP address at the time of login
+	UserAgent  string    `json:"userAgent"`  // UserAgent :: user agent of the client at the time of login
+	AuthMethod string    `json:"authMethod"` // AuthMethod :: 'local', 'ldap' or 'totp'
+	HttpOnly   bool      `json:"httpOnly"`
+	Secure     bool      `json:"secure"`
+	Signed     bool      `json:"-"` // Signed :: true for stateless signed sessions (see signing.go)
+
+	// signingKeyID is the ID of the key that signed the session (signed sessions only)
+	signingKeyID string
+	// persistedLastSeen is the last activity that was saved to the database
+	persistedLastSeen time.Time
 }
 
-// SaveCookie saves a cookie to the database.
+// authentication methods of a session
+const (
+	authMethodLocal = "local"
+	authMethodLDAP  = "ldap"
+	authMethodTOTP  = "totp"
+)
+
+// maxLastSeenWriteInterval defines the maximum interval in which the last activity of a session is saved
+// to the database. The last activity is always updated in the cache, writes to the database are throttled
+// so the /auth route does not hit the database on every request.
+const maxLastSeenWriteInterval = 5 * time.Minute
+
+// SaveCookie saves a cookie to the database and updates the cached copy of the session.
 // Returns nil if the cookie was saved successfully.
 func SaveCookie(cookie Cookie) error {
-	db := initDatabase()
-	defer db.Close()
+	UpdateCookieInCache(&cookie)
 
-	return db.Update(func(tx *bolt.Tx) error {
-		_, _ = tx.CreateBucketIfNotExists([]byte("cookies"))
-		bucket := tx.Bucket([]byte("cookies"))
+	return store.SaveSession(cookie)
+}
 
-		buffer, err := json.Marshal(cookie)
-		if err != nil {
-			return err
+// GetCookieByValue looks up plaintext cookie value and the corresponding user in the database
+// and returns the cookie if found. This function will try to match the given plaintext cookie value
+// to the argon2 hash saved in the database, therefore it is a time-intensive function.
+// Returns nil if the cookie was not found.
+func GetCookieByValue(cookieValue string, username string) *Cookie {
+	cookies, err := store.GetSessionsByUsername(username)
+
+	if err != nil {
+		appLog.Printf("error: could not read cookies of user with username '%s' from the database. %s\n", username, err)
+		return nil
+	}
+
+	for _, cookie := range cookies {
+		if CompareHashAndPassword(cookie.Value, cookieValue) == nil {
+			return &cookie
 		}
+	}
 
-		// Persist bytes to users bucket.
-		return bucket.Put([]byte(cookie.Value), buffer)
-	})
+	return nil
 }
 
-// GetCookies fetches all cookies from the database and returns them.
-func GetCookies() []Cookie {
-	db := initDatabase()
-	defer db.Close()
-
-	var cookies []Cookie
+// PurgeCookies deletes all cookies in the database and in the session cache.
+func PurgeCookies() error {
+	PurgeCookieCache()
 
-	_ = db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("cookies"))
+	return store.PurgeSessions()
+}
 
-		if bucket == nil {
-			return nil
-		}
+// DeleteCookie deletes a specific Cookie from the database and from the session cache.
+func DeleteCookie(cookie *Cookie) error {
+	if cookie == nil {
+		return errors.New("error: provided cookie is nil")
+	}
 
-		_ = bucket.ForEach(func(key, value []byte) error {
-			cookie := Cookie{}
-			_ = json.Unmarshal(value, &cookie)
-			cookies = append(cookies, cookie)
+	DeleteCookieFromCache(cookie)
 
-			return nil
-		})
+	return store.DeleteSession(cookie.Value)
+}
 
-		return nil
-	})
+// DeleteCookiesByUsername deletes all cookies specific to the given username from the database
+// and from the session cache.
+func DeleteCookiesByUsername(username string) error {
+	DeleteCookiesFromCacheByUsername(username)
 
-	return cookies
+	return store.DeleteSessionsByUsername(username)
 }
 
-// GetCookiesByUsername looks up cookies specific to a user in database and returns the cookies.
-func GetCookiesByUsername(username string) []Cookie {
-	db := initDatabase()
-	defer db.Close()
-
-	var cookies []Cookie
+// GetCookieByID looks up the cookie with the given session ID in the database.
+// Returns nil if the cookie was not found.
+func GetCookieByID(id string) (*Cookie, error) {
+	cookies, err := store.GetSessions()
 
-	_ = db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("cookies"))
+	if err != nil {
+		return nil, err
+	}
 
-		if bucket == nil {
-			return nil
+	for _, cookie := range cookies {
+		if id != "" && cookie.ID == id {
+			return &cookie, nil
 		}
+	}
 
-		_ = bucket.ForEach(func(key, value []byte) error {
-			cookie := Cookie{}
-			_ = json.Unmarshal(value, &cookie)
-
-			if cookie.Username == username {
-				cookies = append(cookies, cookie)
-			}
+	return nil, nil
+}
 
-			return nil
-		})
+// RevokeCookieByID deletes the cookie with the given session ID from the database and from the session cache.
+// Returns the deleted cookie or an error if no cookie with the given session ID exists.
+func RevokeCookieByID(id string) (*Cookie, error) {
+	if id == "" {
+		return nil, errors.New("error: no session ID provided")
+	}
 
-		return nil
+	cookies, err := store.DeleteSessionsWhere(func(cookie *Cookie) bool {
+		return cookie.ID == id
 	})
 
-	return cookies
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cookies) == 0 {
+		return nil, errors.New("cookie with ID '" + id + "' does not exist")
+	}
+
+	DeleteCookieFromCache(&cookies[0])
+
+	return &cookies[0], nil
 }
 
-// GetCookieByValue looks up plaintext cookie value and the corresponding user in the database
-// and returns the cookie if found. This function will try to match the given plaintext cookie value
-// to the argon2 hash saved in the database, therefore it is a time-intensive function.
-// Returns nil if the cookie was not found.
-func GetCookieByValue(cookieValue string, username string) *Cookie {
-	var cookies []Cookie
+// errSessionLimitReached is returned by EnforceSessionLimit if the login has to be rejected
+var errSessionLimitReached = errors.New("session limit reached")
 
-	cookies = GetCookiesByUsername(username)
+// EnforceSessionLimit enforces the maximum number of active sessions of the user with the given username before
+// a new session is created. Depending on the configured policy, errSessionLimitReached is returned or the oldest
+// sessions of the user are deleted. Returns the deleted (evicted) cookies.
+func EnforceSessionLimit(username string) ([]Cookie, error) {
+	limit := GetCookieMaxSessions(username)
+
+	// signed sessions are not stored in the database and cannot be counted
+	if limit <= 0 || GetCookieMode() == "signed" {
+		return nil, nil
+	}
+
+	cookies, err := store.GetSessionsByUsername(username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var activeCookies []Cookie
+	now := time.Now()
 
 	for _, cookie := range cookies {
-		if CompareHashAndPassword(cookie.Value, cookieValue) == nil {
-			return &cookie
+		if cookie.Expires.After(now) && !IsCookieIdle(&cookie) {
+			activeCookies = append(activeCookies, cookie)
 		}
 	}
 
-	return nil
-}
+	if len(activeCookies) < limit {
+		return nil, nil
+	}
 
-// PurgeCookies deletes all cookies in the database.
-func PurgeCookies() error {
-	db := initDatabase()
-	defer db.Close()
+	if GetCookieMaxSessionsPolicy() == "reject" {
+		return nil, errSessionLimitReached
+	}
 
-	return db.Update(func(tx *bolt.Tx) error {
-		return tx.DeleteBucket([]byte("cookies"))
+	sort.Slice(activeCookies, func(i, j int) bool {
+		return activeCookies[i].Created.Before(activeCookies[j].Created)
 	})
-}
 
-// DeleteCookie deletes a specific Cookie from the database.
-func DeleteCookie(cookie *Cookie) error {
-	if cookie == nil {
-		return errors.New("error: provided cookie is nil")
-	}
+	// keep room for the new session
+	evictedCookies := activeCookies[:len(activeCookies)-limit+1]
 
-	db := initDatabase()
-	defer db.Close()
+	for i := range evictedCookies {
+		if err = DeleteCookie(&evictedCookies[i]); err != nil {
+			return nil, err
+		}
+	}
 
-	return db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("cookies"))
+	return evictedCookies, nil
+}
 
-		if bucket == nil {
-			return nil
-		}
+// DeleteExpiredCookies deletes all expired and idle cookies from the database and from the session cache.
+// Returns the number of deleted cookies.
+func DeleteExpiredCookies() (int, error) {
+	now := time.Now()
 
-		return bucket.Delete([]byte(cookie.Value))
+	cookies, err := store.DeleteSessionsWhere(func(cookie *Cookie) bool {
+		return cookie.Expires.Before(now) || IsCookieIdle(cookie)
 	})
+
+	for i := range cookies {
+		DeleteCookieFromCache(&cookies[i])
+	}
+
+	return len(cookies), err
 }
 
-// DeleteCookiesByUsername deletes all cookies specific to the given username.
-func DeleteCookiesByUsername(username string) error {
-	db := initDatabase()
-	defer db.Close()
+// runCookieReaper periodically deletes expired and idle cookies in the configured interval
+// until the stop channel is closed.
+func runCookieReaper(stop <-chan struct{}) {
+	interval := time.Duration(GetCookieReaperInterval()) * time.Minute
 
-	return db.Batch(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("cookies"))
+	if interval <= 0 {
+		return
+	}
 
-		if bucket == nil {
-			return nil
-		}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-		return bucket.ForEach(func(key, value []byte) error {
-			cookie := Cookie{}
-			_ = json.Unmarshal(value, &cookie)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			count, err := DeleteExpiredCookies()
 
-			if cookie.Username == username {
-				return bucket.Delete([]byte(cookie.Value))
-			} else {
-				return nil
+			if err != nil {
+				appLog.Printf("error: could not delete expired cookies from database. %s\n", err)
+			} else if count > 0 {
+				appLog.Printf("deleted %d expired cookies from database\n", count)
 			}
-		})
-	})
+		}
+	}
 }
 
 // VerifyCookie returns the Cookie and nil if the given token is valid.
 // Example for token param: '$username=foo,$value=kC6......LOh'.
+// Signed session tokens (example: 'v1.eyJzaWQiOi......In0.kZ3......Q8') are verified without a database lookup.
 // Returns nil and an error if the cookie was not found or expired.
 func VerifyCookie(token string) (*Cookie, error) {
+	if IsSignedSessionToken(token) {
+		cookie, err := VerifySignedSession(token)
+
+		// actively used signed sessions are re-signed before they exceed the idle timeout (see NeedsResigning)
+		if err == nil && IsCookieIdle(cookie) {
+			return nil, errors.New("error: signed session exceeded the idle timeout")
+		}
+
+		return cookie, err
+	}
+
 	username, cookieValue, err := DecodeAuthToken(token)
 
 	if err != nil {
@@ -183,8 +263,17 @@ func VerifyCookie(token string) (*Cookie, error) {
 
 	cookie := GetCookieFromCache(cookieValue)
 
+	// cached sessions are dropped when the user is disabled, so only sessions from the database are checked
 	if cookie == nil {
 		cookie = GetCookieByValue(cookieValue, username)
+
+		if cookie != nil {
+			if disabled, err := IsUserDisabled(cookie.Username); err != nil {
+				return nil, err
+			} else if disabled {
+				return nil, errors.New("error: user is disabled")
+			}
+		}
 	}
 
 	if cookie == nil {
@@ -195,15 +284,131 @@ func VerifyCookie(token string) (*Cookie, error) {
 		if err != nil {
 			return nil, errors.New("error: could not delete expired cookie from database")
 		} else {
-			DeleteCookieFromCache(cookie)
 			return nil, errors.New("error: cookie is expired and was deleted")
 		}
+	} else if IsCookieIdle(cookie) {
+		err := DeleteCookie(cookie)
+
+		if err != nil {
+			return nil, errors.New("error: could not delete idle cookie from database")
+		} else {
+			return nil, errors.New("error: cookie exceeded the idle timeout and was deleted")
+		}
 	} else {
+		TouchCookie(cookie)
 		SaveCookieToCache(cookie, cookieValue)
 		return cookie, nil
 	}
 }
 
+// IsCookieIdle returns true if an idle timeout is configured and the session of the given cookie
+// was not used within the idle timeout.
+func IsCookieIdle(cookie *Cookie) bool {
+	idleTimeout := time.Duration(GetCookieIdleTimeout()) * time.Minute
+
+	if idleTimeout <= 0 {
+		return false
+	}
+
+	lastSeen := cookie.LastSeen
+
+	// cookies created before the last activity was introduced have no last activity
+	if lastSeen.IsZero() {
+		lastSeen = cookie.Created
+	}
+
+	return !lastSeen.IsZero() && lastSeen.Add(idleTimeout).Before(time.Now())
+}
+
+// TouchCookie updates the last activity of the given cookie. The last activity is saved to the database
+// if the previously saved last activity is older than the write interval (a tenth of the idle timeout,
+// at most maxLastSeenWriteInterval).
+func TouchCookie(cookie *Cookie) {
+	now := time.Now()
+
+	if cookie.persistedLastSeen.IsZero() {
+		cookie.persistedLastSeen = cookie.LastSeen
+	}
+
+	cookie.LastSeen = now
+
+	if now.Sub(cookie.persistedLastSeen) < getLastSeenWriteInterval() {
+		return
+	}
+
+	cookie.persistedLastSeen = now
+
+	if err := SaveCookie(*cookie); err != nil {
+		appLog.Printf("error: could not save last activity of cookie for user with username '%s': %s\n", cookie.Username, err)
+	}
+}
+
+// getLastSeenWriteInterval returns the interval in which the last activity of a session is saved: a tenth of the
+// idle timeout, at most maxLastSeenWriteInterval.
+func getLastSeenWriteInterval() time.Duration {
+	writeInterval := time.Duration(GetCookieIdleTimeout()) * time.Minute / 10
+
+	if writeInterval <= 0 || writeInterval > maxLastSeenWriteInterval {
+		writeInterval = maxLastSeenWriteInterval
+	}
+
+	return writeInterval
+}
+
+// RenewCookie extends the expiry of the given cookie by the configured cookie lifetime if sliding expiration
+// is enabled and the remaining lifetime of the cookie fell below the renewal threshold. The expiry is capped by the
+// configured maximum lifetime of the session. Returns true if the cookie was renewed and saved to the database
+// (signed sessions are renewed in memory only).
+func RenewCookie(cookie *Cookie) (bool, error) {
+	if !GetCookieSlidingExpiration() {
+		return false, nil
+	}
+
+	now := time.Now()
+
+	if cookie.Expires.Sub(now) > time.Duration(GetCookieRenewalThreshold())*time.Hour {
+		return false, nil
+	}
+
+	created := cookie.Created
+
+	// cookies created before sliding expiration was introduced have no creation time
+	if created.IsZero() {
+		created = cookie.Expires.AddDate(0, 0, -GetCookieLifetime())
+	}
+
+	expires := now.AddDate(0, 0, GetCookieLifetime())
+
+	if maxExpires := created.AddDate(0, 0, GetCookieMaxLifetime()); expires.After(maxExpires) {
+		expires = maxExpires
+	}
+
+	if !expires.After(cookie.Expires) {
+		return false, nil
+	}
+
+	cookie.Expires = expires
+	cookie.Created = created
+
+	// signed sessions are not saved to the database, the caller has to re-sign the session
+	if cookie.Signed {
+		return true, nil
+	}
+
+	return true, SaveCookie(*cookie)
+}
+
+// GenerateSessionID generates a random session ID and returns it hex encoded.
+func GenerateSessionID() string {
+	id, err := GenerateRandomBytes(16)
+
+	if err != nil {
+		appLog.Fatal("an error occurred while trying to generate a random session ID.")
+	}
+
+	return hex.EncodeToString(id)
+}
+
 // DecodeAuthToken decodes the given token and returns the username and plain cookie value.
 // Returns an error if the given token did not match the expected syntax.
 // Example for token param: '$username=foo,$value=kC6......LOh'.
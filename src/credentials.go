@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// This file handles the verification of user credentials (username, password and TOTP token) against
+// the local user database and LDAP. It is used by the login form as well as the HTTP Basic authentication.
+
+var (
+	// errInvalidCredentials is returned if no local user exists and LDAP did not authenticate the user
+	errInvalidCredentials = errors.New("invalid credentials")
+
+	// errInvalidPassword is returned if the password does not match the password hash of the local user
+	errInvalidPassword = errors.New("invalid password")
+
+	// errInvalidTotp is returned if the TOTP token of a local user with enabled TOTP is invalid
+	errInvalidTotp = errors.New("invalid TOTP")
+
+	// errUserDisabled is returned if the credentials of a disabled local user are valid
+	errUserDisabled = errors.New("user is disabled")
+)
+
+// getAuthMethod returns the authentication method of a successful verifyCredentials call for the given User
+// (nil for LDAP users).
+func getAuthMethod(user *User) string {
+	if user == nil {
+		return authMethodLDAP
+	} else if len(user.OtpSecret) != 0 {
+		return authMethodTOTP
+	}
+
+	return authMethodLocal
+}
+
+// verifyCredentials verifies the given username, password and TOTP token. Local users are prioritized,
+// if no local user with the given username exists, the credentials are validated with LDAP.
+// Returns the local User (nil for LDAP users) and nil if the credentials are valid.
+func verifyCredentials(username string, password string, totpToken string) (*User, error) {
+	user, err := store.GetUser(username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if user == nil {
+		// if a user with the given username does not exist, check if LDAP authenticates
+		if ldapAuthenticate(username, password) {
+			return nil, nil
+		}
+
+		return nil, errInvalidCredentials
+	}
+
+	// if a user with the given username was found in the database, check password validity
+	if CompareHashAndPassword(user.Password, password) != nil {
+		return nil, errInvalidPassword
+	}
+
+	// if TOTP is enabled for the user, check the validity of the TOTP token input from the user
+	if len(user.OtpSecret) != 0 {
+		secret := Decrypt(user.OtpSecret, password)
+
+		if !totp.Validate(totpToken, string(secret)) {
+			return nil, errInvalidTotp
+		}
+	}
+
+	// the disabled state is checked last, so it is only revealed to clients that know the credentials
+	if user.Disabled {
+		return nil, errUserDisabled
+	}
+
+	return user, nil
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/cipher"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"io"
 )
@@ -75,3 +76,12 @@ func GenerateRandomBytes(n uint32) ([]byte, error) {
 
 	return b, nil
 }
+
+// HashSHA256 returns the hex encoded SHA-256 hash of the given value.
+// This function is used to hash high-entropy random secrets (API tokens, one-time codes) before saving them
+// to the database. Unlike passwords, these secrets do not require a slow hash function like argon2.
+func HashSHA256(value string) string {
+	hash := sha256.Sum256([]byte(value))
+
+	return hex.EncodeToString(hash[:])
+}
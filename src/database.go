@@ -1,13 +1,74 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
-	bolt "go.etcd.io/bbolt"
+	"io"
+	"net"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
 )
 
+// This file implements the Store using bbolt. The server keeps a single database handle open for its whole runtime.
+// Since bbolt locks the database file, other processes (i.e. CLI commands) cannot open the database while the server
+// is running. Therefore, the server listens on a unix socket next to the database file. A CLI command that finds
+// the database locked connects to the socket. For read-only operations, the server sends a consistent copy of the
+// database, which the CLI command opens instead, so the server keeps serving requests. For read-write operations,
+// the server closes the database and reopens it once the CLI command closed the connection again.
+
 var databaseFilePath string
 
+var (
+	// schemaCheckMutex guards schemaChecked
+	schemaCheckMutex sync.Mutex
+	// schemaChecked is true once the schema version of a writable database was checked, so CLI commands check
+	// the schema version only once per process
+	schemaChecked bool
+)
+
+const (
+	// databaseLockTimeout is the time a CLI command waits for the database lock before asking
+	// a running server to hand over the database
+	databaseLockTimeout = 100 * time.Millisecond
+
+	// databaseHandoverTimeout is the time a CLI command waits for the database lock after the server
+	// handed over the database
+	databaseHandoverTimeout = 5 * time.Second
+
+	// databaseHandoverMaxDuration is the maximum time the server keeps the database closed for another process
+	// before it closes the connection and reopens the database. Requests of the server wait in the meantime.
+	databaseHandoverMaxDuration = 5 * time.Second
+
+	// databaseSnapshotTimeout is the maximum time the server takes to send a copy of the database
+	databaseSnapshotTimeout = 60 * time.Second
+
+	// databaseHandoverRequest is sent by a CLI command that needs to write to the database
+	databaseHandoverRequest = "handover\n"
+
+	// databaseSnapshotRequest is sent by a CLI command that needs a consistent copy of the database
+	databaseSnapshotRequest = "snapshot\n"
+
+	// databaseReleasedMessage is sent by the server once the database was closed for another process
+	databaseReleasedMessage = "released\n"
+
+	// databaseSnapshotMessage is sent by the server, followed by the size of the copy of the database in bytes and
+	// a newline, before it sends the copy
+	databaseSnapshotMessage = "snapshot "
+
+	// sessionIndexBucketName is the name of the bucket that maps usernames to the hashes of their sessions.
+	// The bucket contains a nested bucket for every user with sessions.
+	sessionIndexBucketName = "cookiesByUsername"
+)
+
 // init will check if the database file is readable/creatable by the application.
 // This function will panic if the database could not be read/created for some reason.
 func init() {
@@ -27,15 +88,980 @@ func init() {
 	}
 }
 
-// initDatabase opens and returns the bbolt database.
+// boltStore is the bbolt implementation of Store.
+type boltStore struct {
+	// mutex guards db, since the database is closed and reopened while it is handed over to another process
+	mutex sync.RWMutex
+	// db is the long-lived database handle of the server, nil if the database is opened for every operation
+	db *bolt.DB
+	// listener accepts the handover requests of other processes
+	listener net.Listener
+	// closed is true once the long-lived database handle was closed by Close
+	closed bool
+}
+
+// boltTx is the bbolt implementation of Tx.
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+// openBoltStore opens the database and returns a boltStore that keeps the database open until it is closed.
+// Other processes can request the database using the unix socket at getDatabaseSocketPath().
+func openBoltStore() (*boltStore, error) {
+	db, err := bolt.Open(databaseFilePath, 0660, &bolt.Options{Timeout: databaseLockTimeout})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not open database at '%s', is another server running? %s", databaseFilePath, err)
+	}
+
+	if _, _, err = migrateDatabase(db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not migrate database at '%s'. %s", databaseFilePath, err)
+	}
+
+	socketPath := getDatabaseSocketPath()
+
+	// remove a stale socket of a server that was not shut down gracefully
+	_ = os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("could not listen on database socket at '%s'. %s", socketPath, err)
+	}
+
+	// only processes that are allowed to open the database can request it (Windows ignores the permission bits)
+	if err = os.Chmod(socketPath, 0660); err != nil && runtime.GOOS != "windows" {
+		_ = listener.Close()
+		_ = db.Close()
+		return nil, fmt.Errorf("could not set permissions of database socket at '%s'. %s", socketPath, err)
+	}
+
+	s := &boltStore{db: db, listener: listener}
+
+	go s.serveHandovers()
+
+	return s, nil
+}
+
+// Close closes the database and stops accepting handover requests.
+func (s *boltStore) Close() error {
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+
+	if s.db == nil {
+		return nil
+	}
+
+	err := s.db.Close()
+	s.db = nil
+
+	return err
+}
+
+// serveHandovers accepts handover and snapshot requests until the listener is closed.
+func (s *boltStore) serveHandovers() {
+	for {
+		conn, err := s.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		go func() {
+			if err := s.serveRequest(conn); err != nil {
+				appLog.Printf("error: %s\n", err)
+			}
+		}()
+	}
+}
+
+// serveRequest reads the request of the process connected to conn and hands over the database or sends a copy
+// of the database.
+func (s *boltStore) serveRequest(conn net.Conn) error {
+	defer conn.Close()
+
+	// a stalled or misbehaving process must not keep the database closed
+	if err := conn.SetDeadline(time.Now().Add(databaseHandoverMaxDuration)); err != nil {
+		return fmt.Errorf("could not serve the request of another process. %s", err)
+	}
+
+	request, err := bufio.NewReader(conn).ReadString('\n')
+
+	if err != nil {
+		return fmt.Errorf("could not read the request of another process. %s", err)
+	}
+
+	switch request {
+	case databaseHandoverRequest:
+		return s.handOver(conn)
+	case databaseSnapshotRequest:
+		return s.sendSnapshot(conn)
+	}
+
+	return fmt.Errorf("received invalid request '%s' from another process", strings.TrimSpace(request))
+}
+
+// sendSnapshot sends a consistent copy of the database to the process connected to conn. The copy is written
+// within a read-only transaction, so the server keeps serving requests in the meantime.
+func (s *boltStore) sendSnapshot(conn net.Conn) error {
+	if err := conn.SetDeadline(time.Now().Add(databaseSnapshotTimeout)); err != nil {
+		return fmt.Errorf("could not send the database to another process. %s", err)
+	}
+
+	err := s.withUncheckedDatabase(true, func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			if _, err := fmt.Fprintf(conn, "%s%d\n", databaseSnapshotMessage, tx.Size()); err != nil {
+				return err
+			}
+
+			_, err := tx.WriteTo(conn)
+
+			return err
+		})
+	})
+
+	if err != nil {
+		return fmt.Errorf("could not send the database to another process. %s", err)
+	}
+
+	return nil
+}
+
+// handOver closes the database, so the process connected to conn can open it. The database is reopened once
+// the other process closed the connection, at the latest after databaseHandoverMaxDuration.
+// Operations of the server wait until the database is reopened. If the database could not be reopened,
+// operations fail until the database is reopened by one of the following operations (see reopen).
+// The caches of the server are purged afterwards, since the other process modified the database.
+func (s *boltStore) handOver(conn net.Conn) error {
+	s.mutex.Lock()
+
+	if s.db != nil {
+		if err := s.db.Close(); err != nil {
+			s.mutex.Unlock()
+			return fmt.Errorf("could not hand over the database to another process. %s", err)
+		}
+
+		s.db = nil
+	}
+
+	if _, err := conn.Write([]byte(databaseReleasedMessage)); err == nil {
+		// wait until the other process is done
+		_, _ = io.Copy(io.Discard, conn)
+	}
+
+	// the other process released the lock before it closed the connection, unless it exceeded the maximum duration
+	db, err := bolt.Open(databaseFilePath, 0660, &bolt.Options{Timeout: databaseLockTimeout})
+
+	if err == nil {
+		s.db = db
+	}
+
+	s.mutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("could not reopen the database after handing it over to another process. %s", err)
+	}
+
+	purgeCachesAfterHandover()
+
+	return nil
+}
+
+// reopen reopens the long-lived database handle of the server if it could not be reopened after a handover.
+// Returns an error if the database is still locked by another process or the store was closed.
+func (s *boltStore) reopen() error {
+	s.mutex.Lock()
+
+	if s.closed {
+		s.mutex.Unlock()
+		return errors.New("error: the database was closed")
+	}
+
+	if s.db != nil {
+		s.mutex.Unlock()
+		return nil
+	}
+
+	db, err := bolt.Open(databaseFilePath, 0660, &bolt.Options{Timeout: databaseLockTimeout})
+
+	if err != nil {
+		s.mutex.Unlock()
+		return fmt.Errorf("error: the database is not available. %s\n", err)
+	}
+
+	s.db = db
+	s.mutex.Unlock()
+
+	purgeCachesAfterHandover()
+
+	return nil
+}
+
+// purgeCachesAfterHandover purges the in-memory state of the server after the database was handed over,
+// since the other process might have modified users, sessions or signing keys.
+func purgeCachesAfterHandover() {
+	PurgeCookieCache()
+	PurgeBasicAuthCache()
+	loadSigningState(true)
+}
+
+// withDatabase executes the given function with the long-lived database handle. If the store does not keep
+// the database open, the database is opened for the function and closed afterwards (see acquireDatabase). If readOnly
+// is true, the function only reads from the database. The schema version of the database is checked once
+// (see prepareDatabase). The function must not call other methods of the store.
+func (s *boltStore) withDatabase(readOnly bool, fn func(db *bolt.DB) error) error {
+	return s.withUncheckedDatabase(readOnly, func(db *bolt.DB) error {
+		if err := checkSchema(db); err != nil {
+			return err
+		}
+
+		return fn(db)
+	})
+}
+
+// checkSchema checks the schema version of the given database once (see prepareDatabase). Read-only databases are
+// checked every time, since empty databases are migrated by the first read-write operation.
+func checkSchema(db *bolt.DB) error {
+	schemaCheckMutex.Lock()
+	defer schemaCheckMutex.Unlock()
+
+	if schemaChecked {
+		return nil
+	}
+
+	if err := prepareDatabase(db); err != nil {
+		return fmt.Errorf("error: %w\n", err)
+	}
+
+	schemaChecked = !db.IsReadOnly()
+
+	return nil
+}
+
+// withUncheckedDatabase is like withDatabase, but does not check the schema version of the database.
+// It is used by the maintenance commands (migrate, backup, restore) that must work with outdated databases.
+func (s *boltStore) withUncheckedDatabase(readOnly bool, fn func(db *bolt.DB) error) error {
+	s.mutex.RLock()
+
+	if s.db != nil {
+		defer s.mutex.RUnlock()
+		return fn(s.db)
+	}
+
+	s.mutex.RUnlock()
+
+	// the server does not open the database for single operations, but reopens its long-lived handle
+	if s.listener != nil {
+		if err := s.reopen(); err != nil {
+			return err
+		}
+
+		return s.withUncheckedDatabase(readOnly, fn)
+	}
+
+	db, release := acquireDatabase(readOnly)
+	defer release()
+
+	return fn(db)
+}
+
+// view executes the given function within a read-only bbolt transaction.
+func (s *boltStore) view(fn func(tx *bolt.Tx) error) error {
+	return s.withDatabase(true, func(db *bolt.DB) error {
+		return db.View(fn)
+	})
+}
+
+// update executes the given function within a read-write bbolt transaction.
+func (s *boltStore) update(fn func(tx *bolt.Tx) error) error {
+	return s.withDatabase(false, func(db *bolt.DB) error {
+		return db.Update(fn)
+	})
+}
+
+// View implements Store.
+func (s *boltStore) View(fn func(tx Tx) error) error {
+	return s.view(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx: tx})
+	})
+}
+
+// Update implements Store.
+func (s *boltStore) Update(fn func(tx Tx) error) error {
+	return s.update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx: tx})
+	})
+}
+
+// Bucket implements Tx.
+func (t boltTx) Bucket(name string) Bucket {
+	if t.tx.Writable() {
+		bucket, err := t.tx.CreateBucketIfNotExists([]byte(name))
+
+		if err != nil {
+			return nil
+		}
+
+		return bucket
+	}
+
+	if bucket := t.tx.Bucket([]byte(name)); bucket != nil {
+		return bucket
+	}
+
+	return nil
+}
+
+// DeleteBucket implements Tx.
+func (t boltTx) DeleteBucket(name string) error {
+	if err := t.tx.DeleteBucket([]byte(name)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+		return err
+	}
+
+	return nil
+}
+
+// GetUser implements UserStore.
+func (s *boltStore) GetUser(username string) (*User, error) {
+	var user *User
+
+	err := s.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("users"))
+
+		if bucket == nil {
+			return nil
+		}
+
+		value := bucket.Get([]byte(username))
+
+		if value == nil {
+			return nil
+		}
+
+		return unmarshalValue("users", []byte(username), value, &user)
+	})
+
+	return user, err
+}
+
+// GetUsers implements UserStore.
+func (s *boltStore) GetUsers() ([]User, error) {
+	var users []User
+
+	err := s.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("users"))
+
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			user := User{}
+
+			if err := unmarshalValue("users", key, value, &user); err != nil {
+				return err
+			}
+
+			users = append(users, user)
+
+			return nil
+		})
+	})
+
+	return users, err
+}
+
+// SaveUser implements UserStore.
+func (s *boltStore) SaveUser(user User) error {
+	buffer, err := marshalValue("users", []byte(user.Username), user)
+
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("users"))
+
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(user.Username), buffer)
+	})
+}
+
+// DeleteUser implements UserStore.
+func (s *boltStore) DeleteUser(username string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("users"))
+
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Delete([]byte(username))
+	})
+}
+
+// GetSession implements SessionStore.
+func (s *boltStore) GetSession(hash string) (*Cookie, error) {
+	var cookie *Cookie
+
+	err := s.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("cookies"))
+
+		if bucket == nil {
+			return nil
+		}
+
+		value := bucket.Get([]byte(hash))
+
+		if value == nil {
+			return nil
+		}
+
+		return unmarshalValue("cookies", []byte(hash), value, &cookie)
+	})
+
+	return cookie, err
+}
+
+// GetSessions implements SessionStore.
+func (s *boltStore) GetSessions() ([]Cookie, error) {
+	return s.getSessions(func(cookie *Cookie) bool {
+		return true
+	})
+}
+
+// GetSessionsByUsername implements SessionStore. The sessions are looked up using the username index.
+func (s *boltStore) GetSessionsByUsername(username string) ([]Cookie, error) {
+	var cookies []Cookie
+
+	err := s.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("cookies"))
+		index := getSessionIndex(tx, username)
+
+		if bucket == nil || index == nil {
+			return nil
+		}
+
+		return index.ForEach(func(hash, _ []byte) error {
+			value := bucket.Get(hash)
+
+			if value == nil {
+				return nil
+			}
+
+			cookie := Cookie{}
+
+			if err := unmarshalValue("cookies", hash, value, &cookie); err != nil {
+				return err
+			}
+
+			cookies = append(cookies, cookie)
+
+			return nil
+		})
+	})
+
+	return cookies, err
+}
+
+// getSessions returns all sessions matching the given filter.
+func (s *boltStore) getSessions(filter func(cookie *Cookie) bool) ([]Cookie, error) {
+	var cookies []Cookie
+
+	err := s.view(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("cookies"))
+
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			cookie := Cookie{}
+
+			if err := unmarshalValue("cookies", key, value, &cookie); err != nil {
+				return err
+			}
+
+			if filter(&cookie) {
+				cookies = append(cookies, cookie)
+			}
+
+			return nil
+		})
+	})
+
+	return cookies, err
+}
+
+// SaveSession implements SessionStore. The username index is updated in the same transaction.
+func (s *boltStore) SaveSession(cookie Cookie) error {
+	buffer, err := marshalValue("cookies", []byte(cookie.Value), cookie)
+
+	if err != nil {
+		return err
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("cookies"))
+
+		if err != nil {
+			return err
+		}
+
+		if err = bucket.Put([]byte(cookie.Value), buffer); err != nil {
+			return err
+		}
+
+		index, err := createSessionIndex(tx, cookie.Username)
+
+		if err != nil {
+			return err
+		}
+
+		return index.Put([]byte(cookie.Value), []byte{})
+	})
+}
+
+// DeleteSession implements SessionStore. The username index is updated in the same transaction.
+func (s *boltStore) DeleteSession(hash string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("cookies"))
+
+		if bucket == nil {
+			return nil
+		}
+
+		value := bucket.Get([]byte(hash))
+
+		if value == nil {
+			return nil
+		}
+
+		cookie := Cookie{}
+
+		if err := unmarshalValue("cookies", []byte(hash), value, &cookie); err != nil {
+			return err
+		}
+
+		return deleteSession(tx, bucket, &cookie)
+	})
+}
+
+// DeleteSessionsByUsername implements SessionStore. The sessions are looked up using the username index.
+func (s *boltStore) DeleteSessionsByUsername(username string) error {
+	return s.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("cookies"))
+		index := getSessionIndex(tx, username)
+
+		if bucket == nil || index == nil {
+			return nil
+		}
+
+		err := index.ForEach(func(hash, _ []byte) error {
+			return bucket.Delete(hash)
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(sessionIndexBucketName)).DeleteBucket([]byte(username))
+	})
+}
+
+// DeleteSessionsWhere implements SessionStore.
+func (s *boltStore) DeleteSessionsWhere(filter func(cookie *Cookie) bool) ([]Cookie, error) {
+	var cookies []Cookie
+
+	err := s.update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte("cookies"))
+
+		if bucket == nil {
+			return nil
+		}
+
+		err := bucket.ForEach(func(key, value []byte) error {
+			cookie := Cookie{}
+
+			if err := unmarshalValue("cookies", key, value, &cookie); err != nil {
+				return err
+			}
+
+			if filter(&cookie) {
+				cookies = append(cookies, cookie)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		// the bucket must not be modified while iterating over it
+		for i := range cookies {
+			if err = deleteSession(tx, bucket, &cookies[i]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cookies, nil
+}
+
+// PurgeSessions implements SessionStore.
+func (s *boltStore) PurgeSessions() error {
+	return s.update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"cookies", sessionIndexBucketName} {
+			if err := tx.DeleteBucket([]byte(name)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Import implements Store.
+func (s *boltStore) Import(users []User, sessions []Cookie, replace bool) error {
+	// encrypt the values before the transaction
+	userValues := make([][]byte, len(users))
+	sessionValues := make([][]byte, len(sessions))
+
+	for i, user := range users {
+		value, err := marshalValue("users", []byte(user.Username), user)
+
+		if err != nil {
+			return err
+		}
+
+		userValues[i] = value
+	}
+
+	for i, cookie := range sessions {
+		value, err := marshalValue("cookies", []byte(cookie.Value), cookie)
+
+		if err != nil {
+			return err
+		}
+
+		sessionValues[i] = value
+	}
+
+	return s.update(func(tx *bolt.Tx) error {
+		if replace {
+			for _, name := range []string{"users", "cookies", sessionIndexBucketName} {
+				if err := tx.DeleteBucket([]byte(name)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+					return err
+				}
+			}
+		}
+
+		userBucket, err := tx.CreateBucketIfNotExists([]byte("users"))
+
+		if err != nil {
+			return err
+		}
+
+		for i, user := range users {
+			if err = userBucket.Put([]byte(user.Username), userValues[i]); err != nil {
+				return err
+			}
+		}
+
+		sessionBucket, err := tx.CreateBucketIfNotExists([]byte("cookies"))
+
+		if err != nil {
+			return err
+		}
+
+		for i, cookie := range sessions {
+			if err = sessionBucket.Put([]byte(cookie.Value), sessionValues[i]); err != nil {
+				return err
+			}
+
+			index, err := createSessionIndex(tx, cookie.Username)
+
+			if err != nil {
+				return err
+			}
+
+			if err = index.Put([]byte(cookie.Value), []byte{}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// getSessionIndex returns the bucket of the username index that contains the hashes of the sessions of the user
+// with the given username. Returns nil if the user has no sessions.
+func getSessionIndex(tx *bolt.Tx, username string) *bolt.Bucket {
+	index := tx.Bucket([]byte(sessionIndexBucketName))
+
+	if index == nil {
+		return nil
+	}
+
+	return index.Bucket([]byte(username))
+}
+
+// createSessionIndex returns the bucket of the username index that contains the hashes of the sessions of the user
+// with the given username. The bucket is created if it does not exist.
+func createSessionIndex(tx *bolt.Tx, username string) (*bolt.Bucket, error) {
+	index, err := tx.CreateBucketIfNotExists([]byte(sessionIndexBucketName))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return index.CreateBucketIfNotExists([]byte(username))
+}
+
+// deleteSession deletes the given session from the cookies bucket and from the username index.
+func deleteSession(tx *bolt.Tx, bucket *bolt.Bucket, cookie *Cookie) error {
+	if err := bucket.Delete([]byte(cookie.Value)); err != nil {
+		return err
+	}
+
+	index := getSessionIndex(tx, cookie.Username)
+
+	if index == nil {
+		return nil
+	}
+
+	if err := index.Delete([]byte(cookie.Value)); err != nil {
+		return err
+	}
+
+	// remove the bucket of users without sessions
+	if key, _ := index.Cursor().First(); key == nil {
+		return tx.Bucket([]byte(sessionIndexBucketName)).DeleteBucket([]byte(cookie.Username))
+	}
+
+	return nil
+}
+
+// unmarshalValue decrypts the given value of the given bucket and key (see encryption.go) and unmarshals the JSON
+// into v.
+func unmarshalValue(bucket string, key []byte, value []byte, v interface{}) error {
+	plaintext, err := openValue(bucket, key, value)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(plaintext, v)
+}
+
+// marshalValue marshals v to JSON and encrypts it for the given bucket and key (see encryption.go).
+func marshalValue(bucket string, key []byte, v interface{}) ([]byte, error) {
+	buffer, err := json.Marshal(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sealValue(bucket, key, buffer)
+}
+
+// acquireDatabase opens the database for a single operation. If the database is held open by a running server,
+// the server is asked to hand over the database. If readOnly is true, the server is asked for a copy of the database
+// instead, which is opened read-only. The returned function closes the database and hands it back.
 // This function will panic if the database could not be accessed for some reason.
-func initDatabase() *bolt.DB {
-	// TODO: can cause delays on concurrent calls
-	db, err := bolt.Open(databaseFilePath, 0660, nil)
+func acquireDatabase(readOnly bool) (*bolt.DB, func()) {
+	// the database file is created by the first operation
+	if _, err := os.Stat(databaseFilePath); os.IsNotExist(err) {
+		readOnly = false
+	}
+
+	db, err := bolt.Open(databaseFilePath, 0660, &bolt.Options{Timeout: databaseLockTimeout, ReadOnly: readOnly})
+
+	if err == nil {
+		return db, func() { _ = db.Close() }
+	}
+
+	if !errors.Is(err, bolt.ErrTimeout) {
+		appLog.Fatalf("could not open/create database. %s\n", err)
+	}
+
+	conn, err := net.Dial("unix", getDatabaseSocketPath())
 
 	if err != nil {
+		// no server is listening, the database is locked by another CLI command
+		db, err = bolt.Open(databaseFilePath, 0660, &bolt.Options{ReadOnly: readOnly})
+
+		if err != nil {
+			appLog.Fatalf("could not open/create database. %s\n", err)
+		}
+
+		return db, func() { _ = db.Close() }
+	}
+
+	if readOnly {
+		defer conn.Close()
+
+		return openSnapshot(conn)
+	}
+
+	if _, err = conn.Write([]byte(databaseHandoverRequest)); err != nil {
+		_ = conn.Close()
+		appLog.Fatalf("could not open database, the running server did not release the database. %s\n", err)
+	}
+
+	if message, err := bufio.NewReader(conn).ReadString('\n'); err != nil || message != databaseReleasedMessage {
+		_ = conn.Close()
+		appLog.Fatalf("could not open database, the running server did not release the database. %v\n", err)
+	}
+
+	db, err = bolt.Open(databaseFilePath, 0660, &bolt.Options{Timeout: databaseHandoverTimeout})
+
+	if err != nil {
+		_ = conn.Close()
 		appLog.Fatalf("could not open/create database. %s\n", err)
 	}
 
-	return db
+	return db, func() {
+		_ = db.Close()
+		_ = conn.Close()
+	}
+}
+
+// openSnapshot receives a copy of the database from the server connected to conn and opens it read-only.
+// The copy is stored in a temporary file next to the database, which is removed by the returned function.
+// This function will panic if the copy could not be received for some reason.
+func openSnapshot(conn net.Conn) (*bolt.DB, func()) {
+	file, err := os.CreateTemp(filepath.Dir(databaseFilePath), "nginx-auth-server-snapshot-*.db")
+
+	if err != nil {
+		appLog.Fatalf("could not create a copy of the database. %s\n", err)
+	}
+
+	path := file.Name()
+
+	_, err = receiveSnapshot(conn, file)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(path)
+		appLog.Fatalf("could not open database, the running server did not send a copy of the database. %s\n", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+
+	if err != nil {
+		_ = os.Remove(path)
+		appLog.Fatalf("could not open the copy of the database. %s\n", err)
+	}
+
+	return db, func() {
+		_ = db.Close()
+		_ = os.Remove(path)
+	}
+}
+
+// receiveSnapshot requests a copy of the database from the server connected to conn and writes it to w.
+// Returns the size of the copy in bytes.
+func receiveSnapshot(conn net.Conn, w io.Writer) (int64, error) {
+	if _, err := conn.Write([]byte(databaseSnapshotRequest)); err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(conn)
+	message, err := reader.ReadString('\n')
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !strings.HasPrefix(message, databaseSnapshotMessage) {
+		return 0, fmt.Errorf("unexpected message '%s'", strings.TrimSpace(message))
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(message, databaseSnapshotMessage)), 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("unexpected message '%s'", strings.TrimSpace(message))
+	}
+
+	return io.CopyN(w, reader, size)
+}
+
+// copyDatabase writes a consistent copy of the database to w within a single read-only transaction. If the database
+// is held open by a running server, the server sends the copy, so the server keeps serving requests.
+// Returns the size of the copy in bytes.
+func (s *boltStore) copyDatabase(w io.Writer) (int64, error) {
+	var size int64
+
+	writeTo := func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			var err error
+			size, err = tx.WriteTo(w)
+
+			return err
+		})
+	}
+
+	// the server writes the copy using its long-lived database handle
+	if s.listener != nil {
+		err := s.withUncheckedDatabase(true, writeTo)
+		return size, err
+	}
+
+	db, err := bolt.Open(databaseFilePath, 0660, &bolt.Options{Timeout: databaseLockTimeout, ReadOnly: true})
+
+	if err == nil {
+		defer func() { _ = db.Close() }()
+		return size, writeTo(db)
+	}
+
+	if !errors.Is(err, bolt.ErrTimeout) {
+		return 0, fmt.Errorf("could not open database. %s", err)
+	}
+
+	conn, err := net.Dial("unix", getDatabaseSocketPath())
+
+	if err != nil {
+		// no server is listening, the database is locked by another CLI command
+		if db, err = bolt.Open(databaseFilePath, 0660, &bolt.Options{ReadOnly: true}); err != nil {
+			return 0, fmt.Errorf("could not open database. %s", err)
+		}
+
+		defer func() { _ = db.Close() }()
+		return size, writeTo(db)
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	if size, err = receiveSnapshot(conn, w); err != nil {
+		return size, fmt.Errorf("the running server did not send a copy of the database. %s", err)
+	}
+
+	return size, nil
+}
+
+// getDatabaseSocketPath returns the path of the unix socket the server listens on for handover requests.
+func getDatabaseSocketPath() string {
+	return strings.TrimSuffix(databaseFilePath, ".db") + ".sock"
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStoreRequestsOfOtherProcesses(t *testing.T) {
+	previousStore := store
+	previousPath := databaseFilePath
+	databaseFilePath = filepath.Join(t.TempDir(), "nginx-auth-server.db")
+
+	server, err := openBoltStore()
+
+	if err != nil {
+		t.Fatalf("could not open database: %s", err)
+	}
+
+	store = server
+
+	t.Cleanup(func() {
+		_ = server.Close()
+		store = previousStore
+		databaseFilePath = previousPath
+		PurgeCookieCache()
+		resetSigningState()
+	})
+
+	if err = server.SaveUser(User{Username: "alice", Password: "hash-alice"}); err != nil {
+		t.Fatalf("SaveUser: %s", err)
+	}
+
+	// a store without a long-lived database handle, like the store of a CLI command
+	cli := &boltStore{}
+	cachedCookie := &Cookie{Value: "hash", Username: "alice", Expires: time.Now().Add(time.Hour)}
+	SaveCookieToCache(cachedCookie, "plain-hash")
+
+	users, err := cli.GetUsers()
+
+	if err != nil || len(users) != 1 || users[0].Username != "alice" {
+		t.Fatalf("GetUsers of the CLI: got %v, %v", users, err)
+	}
+
+	var backup bytes.Buffer
+
+	if size, err := cli.copyDatabase(&backup); err != nil || size == 0 || int64(backup.Len()) != size {
+		t.Fatalf("copyDatabase of the CLI: got %d bytes (%d written), %v", size, backup.Len(), err)
+	}
+
+	server.mutex.RLock()
+	open := server.db != nil
+	server.mutex.RUnlock()
+
+	if !open {
+		t.Errorf("the server closed the database for read-only operations")
+	}
+
+	if GetCookieFromCache("plain-hash") == nil {
+		t.Errorf("the server purged its caches after read-only operations")
+	}
+
+	if err = cli.SaveUser(User{Username: "bob", Password: "hash-bob"}); err != nil {
+		t.Fatalf("SaveUser of the CLI: %s", err)
+	}
+
+	// the server reopens the database and purges its caches asynchronously after the handover
+	deadline := time.Now().Add(databaseHandoverMaxDuration)
+
+	for GetCookieFromCache("plain-hash") != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("the server did not purge its caches after a read-write operation")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if user, err := server.GetUser("bob"); err != nil || user == nil {
+		t.Fatalf("the server does not see the user saved by the CLI: %v, %v", user, err)
+	}
+
+	// the copy is a valid database
+	path := filepath.Join(t.TempDir(), "backup.db")
+
+	if _, err = BackupDatabase(path); err != nil {
+		t.Fatalf("BackupDatabase: %s", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+
+	if err != nil {
+		t.Fatalf("could not open backup: %s", err)
+	}
+
+	defer func() { _ = db.Close() }()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("users")).Get([]byte("bob")) == nil {
+			t.Errorf("the backup does not contain the user saved by the CLI")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+}
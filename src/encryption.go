@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// This file implements the optional encryption at rest of the values in the 'users' and 'cookies' buckets.
+// The values are encrypted with AES-256-GCM using the master keys configured in section [Encryption] or in the
+// environment variable NGINX_AUTH_SERVER_ENCRYPTION_KEYS. Every master key has an ID, which is prepended to the
+// encrypted values ('enc:<key ID>:<nonce><ciphertext>'), so values can be decrypted after a new key was added.
+// New values are always encrypted with the current (first) key, 'db rekey' re-encrypts all existing values.
+// Unencrypted values are read as is, so the encryption can be enabled for existing databases.
+// The bucket name and the key of a value are authenticated as additional data, so values cannot be swapped.
+// Users and sessions stored in Redis are encrypted the same way (see store_redis.go).
+// The keys are not encrypted: usernames remain readable as keys of the 'users' bucket, as names of the nested
+// buckets of the session index and in the keys of the Redis store.
+
+const (
+	// encryptionKeysEnvironmentVariable is the name of the environment variable that contains the master keys.
+	// The environment variable takes precedence over the key file.
+	encryptionKeysEnvironmentVariable = "NGINX_AUTH_SERVER_ENCRYPTION_KEYS"
+
+	// encryptedValuePrefix is the prefix of encrypted values, followed by the key ID and a colon
+	encryptedValuePrefix = "enc:"
+)
+
+// encryptedBuckets contains the names of the buckets whose values are encrypted
+var encryptedBuckets = []string{"users", "cookies"}
+
+// masterKeyIDPattern defines the allowed characters of master key IDs
+var masterKeyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// masterKey is a key used to encrypt values in the database.
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+var (
+	// masterKeys contains the configured master keys, the first key is the current key
+	masterKeys     []masterKey
+	masterKeysErr  error
+	masterKeysOnce sync.Once
+)
+
+// getMasterKeys returns the configured master keys. The first key is the current key.
+// Returns no keys if encryption at rest is not configured.
+func getMasterKeys() ([]masterKey, error) {
+	masterKeysOnce.Do(func() {
+		source := "environment variable " + encryptionKeysEnvironmentVariable
+		text := os.Getenv(encryptionKeysEnvironmentVariable)
+
+		if text == "" && GetEncryptionKeyFile() != "" {
+			source = "key file at '" + GetEncryptionKeyFile() + "'"
+
+			buffer, err := os.ReadFile(GetEncryptionKeyFile())
+
+			if err != nil {
+				masterKeysErr = fmt.Errorf("could not read the encryption key file. %s", err)
+				return
+			}
+
+			text = string(buffer)
+		}
+
+		if masterKeys, masterKeysErr = parseMasterKeys(text); masterKeysErr != nil {
+			masterKeysErr = fmt.Errorf("invalid encryption keys in %s. %s", source, masterKeysErr)
+		}
+	})
+
+	return masterKeys, masterKeysErr
+}
+
+// parseMasterKeys parses the given master keys. Keys are separated by newlines or commas and have the format
+// '<key ID>:<base64 encoded 256 bit key>'. Empty lines and lines starting with '#' are ignored.
+func parseMasterKeys(text string) ([]masterKey, error) {
+	var keys []masterKey
+
+	entries := strings.FieldsFunc(text, func(r rune) bool {
+		return r == '\n' || r == ','
+	})
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		id, encodedKey, found := strings.Cut(entry, ":")
+
+		if !found || !masterKeyIDPattern.MatchString(id) {
+			return nil, errors.New("expected '<key ID>:<base64 encoded key>', the key ID may contain letters, digits, '_' and '-'")
+		}
+
+		for _, key := range keys {
+			if key.id == id {
+				return nil, fmt.Errorf("duplicate key ID '%s'", id)
+			}
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(encodedKey)
+
+		if err != nil || len(secret) != 32 {
+			return nil, fmt.Errorf("key '%s' is not a base64 encoded 256 bit key", id)
+		}
+
+		block, err := aes.NewCipher(secret)
+
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, masterKey{id: id, aead: aead})
+	}
+
+	return keys, nil
+}
+
+// GenerateMasterKey generates a new master key with a random key ID in the format of the key file.
+func GenerateMasterKey() (string, error) {
+	id, err := GenerateRandomBytes(4)
+
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := GenerateRandomBytes(32)
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id) + ":" + base64.StdEncoding.EncodeToString(secret), nil
+}
+
+// sealValue encrypts the given value of the given bucket and key with the current master key.
+// Returns the value as is if encryption at rest is not configured.
+func sealValue(bucket string, key []byte, value []byte) ([]byte, error) {
+	keys, err := getMasterKeys()
+
+	if err != nil || len(keys) == 0 {
+		return value, err
+	}
+
+	return sealValueWithKey(keys[0], bucket, key, value)
+}
+
+// sealValueWithKey encrypts the given value of the given bucket and key with the given master key.
+func sealValueWithKey(masterKey masterKey, bucket string, key []byte, value []byte) ([]byte, error) {
+	nonce, err := GenerateRandomBytes(uint32(masterKey.aead.NonceSize()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := []byte(encryptedValuePrefix + masterKey.id + ":")
+	sealed = append(sealed, nonce...)
+
+	return masterKey.aead.Seal(sealed, nonce, value, additionalData(bucket, key)), nil
+}
+
+// openValue decrypts the given value of the given bucket and key with the master key the value was encrypted with.
+// Returns the value as is if the value is not encrypted.
+func openValue(bucket string, key []byte, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, []byte(encryptedValuePrefix)) {
+		return value, nil
+	}
+
+	id, sealed, found := bytes.Cut(value[len(encryptedValuePrefix):], []byte(":"))
+
+	if !found {
+		return nil, fmt.Errorf("invalid encrypted value of key '%s' in bucket '%s'", key, bucket)
+	}
+
+	keys, err := getMasterKeys()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, masterKey := range keys {
+		if masterKey.id != string(id) {
+			continue
+		}
+
+		nonceSize := masterKey.aead.NonceSize()
+
+		if len(sealed) < nonceSize {
+			return nil, fmt.Errorf("invalid encrypted value of key '%s' in bucket '%s'", key, bucket)
+		}
+
+		plaintext, err := masterKey.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additionalData(bucket, key))
+
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt value of key '%s' in bucket '%s'. %s", key, bucket, err)
+		}
+
+		return plaintext, nil
+	}
+
+	return nil, fmt.Errorf("value of key '%s' in bucket '%s' is encrypted with the unknown key '%s'", key, bucket, id)
+}
+
+// additionalData returns the additional data that is authenticated together with a value of the given bucket and key.
+func additionalData(bucket string, key []byte) []byte {
+	return append([]byte(bucket+"\x00"), key...)
+}
+
+// RekeyDatabase re-encrypts all values in the encrypted buckets with the current master key within a single
+// transaction. Users and sessions stored in Redis are re-encrypted beforehand (not within the transaction).
+// If decrypt is true, the values are decrypted instead, which allows disabling the encryption at rest.
+// Returns the number of re-encrypted values.
+func RekeyDatabase(decrypt bool) (int, error) {
+	keys, err := getMasterKeys()
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(keys) == 0 && !decrypt {
+		return 0, errors.New("no encryption key is configured, configure 'key_file' in section [Encryption] or " + encryptionKeysEnvironmentVariable)
+	}
+
+	s, err := getBoltStore()
+
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	// users and sessions stored in Redis are re-encrypted separately
+	if split, ok := store.(*splitStore); ok {
+		var masterKey *masterKey
+
+		if !decrypt {
+			masterKey = &keys[0]
+		}
+
+		if count, err = split.redis.Rekey(masterKey); err != nil {
+			return count, err
+		}
+	}
+
+	err = s.withDatabase(false, func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			for _, name := range encryptedBuckets {
+				bucket := tx.Bucket([]byte(name))
+
+				if bucket == nil {
+					continue
+				}
+
+				values := make(map[string][]byte)
+
+				err := bucket.ForEach(func(key, value []byte) error {
+					plaintext, err := openValue(name, key, value)
+
+					if err != nil {
+						return err
+					}
+
+					if !decrypt {
+						if plaintext, err = sealValueWithKey(keys[0], name, key, plaintext); err != nil {
+							return err
+						}
+					}
+
+					// plaintext may refer to the memory of the database
+					values[string(key)] = append([]byte(nil), plaintext...)
+
+					return nil
+				})
+
+				if err != nil {
+					return err
+				}
+
+				// the bucket must not be modified while iterating over it
+				for key, value := range values {
+					if err = bucket.Put([]byte(key), value); err != nil {
+						return err
+					}
+				}
+
+				count += len(values)
+			}
+
+			return nil
+		})
+	})
+
+	return count, err
+}
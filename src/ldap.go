@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"github.com/go-ldap/ldap/v3"
+	"strings"
 )
 
 // This file handles any logic related to the LDAP interface.
@@ -61,6 +62,97 @@ func ldapCheckUserExists(username string) bool {
 	}
 }
 
+// ldapGetUserGroups returns the common names (CN) of all groups the LDAP user with the given username
+// is a member of (using the 'memberOf' attribute). Returns nil if the user was not found.
+func ldapGetUserGroups(username string) []string {
+	entry := ldapSearchUser(username, []string{"memberOf"})
+
+	if entry == nil {
+		return nil
+	}
+
+	var groups []string
+
+	for _, groupDn := range entry.GetAttributeValues("memberOf") {
+		dn, err := ldap.ParseDN(groupDn)
+
+		if err != nil || len(dn.RDNs) == 0 {
+			continue
+		}
+
+		for _, attribute := range dn.RDNs[0].Attributes {
+			if strings.EqualFold(attribute.Type, "cn") {
+				groups = append(groups, attribute.Value)
+			}
+		}
+	}
+
+	return groups
+}
+
+// ldapGetUserEmail returns the email address (using the 'mail' attribute) of the LDAP user with the given username.
+// Returns an empty string if the user was not found or has no email address.
+func ldapGetUserEmail(username string) string {
+	entry := ldapSearchUser(username, []string{"mail"})
+
+	if entry == nil {
+		return ""
+	}
+
+	return entry.GetAttributeValue("mail")
+}
+
+// ldapGetUserDisplayName returns the display name (attribute 'displayName', or 'cn' if not set) of the LDAP user
+// with the given username. Returns an empty string if the user was not found.
+func ldapGetUserDisplayName(username string) string {
+	entry := ldapSearchUser(username, []string{"displayName", "cn"})
+
+	if entry == nil {
+		return ""
+	}
+
+	if displayName := entry.GetAttributeValue("displayName"); displayName != "" {
+		return displayName
+	}
+
+	return entry.GetAttributeValue("cn")
+}
+
+// ldapSearchUser searches the LDAP user with the given username and returns the ldap.Entry
+// containing the given attributes. Returns nil if the user was not found.
+func ldapSearchUser(username string, attributes []string) *ldap.Entry {
+	if !GetLDAPEnabled() {
+		return nil
+	}
+
+	l := ldapConnect()
+
+	if l == nil {
+		return nil
+	}
+
+	defer l.Close()
+
+	result, err := l.Search(&ldap.SearchRequest{
+		BaseDN:       fmt.Sprintf("ou=%s,%s", GetLDAPOrganizationalUnit(), GetLDAPDomainComponents()),
+		Scope:        ldap.ScopeWholeSubtree,
+		DerefAliases: ldap.NeverDerefAliases,
+		Filter:       fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(username)),
+		Attributes:   attributes,
+	})
+
+	if err != nil {
+		appLog.Printf("error searching LDAP user with username '%s': %s\n", username, err)
+		return nil
+	}
+
+	if len(result.Entries) == 0 {
+		return nil
+	}
+
+	return result.Entries[0]
+}
+
 // ldapConnect connects to the LDAP server and returns the ldap.Conn.
 // Returns nil if the connection failed.
 func ldapConnect() *ldap.Conn {
@@ -11,10 +11,13 @@ import (
 	"io"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -50,6 +53,22 @@ func main() {
 
 // runGin sets up the Gin router and starts the webserver.
 func runGin() {
+	// fail early if the encryption keys of the database are invalid
+	if _, err := getMasterKeys(); err != nil {
+		appLog.Fatalf("fatal error: %s\n", err)
+	}
+
+	// keep the database open for the runtime of the server
+	boltStore, err := openBoltStore()
+
+	if err != nil {
+		appLog.Fatalf("fatal error: %s\n", err)
+	}
+
+	// replace the store of the CLI, which opens the database for every operation
+	_ = store.Close()
+	store = newStore(boltStore)
+
 	gin.SetMode(GinMode)
 
 	router := gin.Default()
@@ -62,6 +81,24 @@ func runGin() {
 	router.POST("/login", processLoginForm)
 	router.GET("/logout", logout)
 	router.GET("/whoami", whoami)
+	router.GET("/sessions", listSessions)
+	router.DELETE("/sessions/:id", revokeSession)
+	router.GET("/account/password", accountPassword)
+	router.POST("/account/password", processPasswordForm)
+	router.GET("/sso/authorize", ssoAuthorize)
+	router.GET("/sso/consume", ssoConsume)
+
+	if GetForwardAuthEnabled() {
+		router.GET("/auth/forward", forwardAuthenticate)
+	}
+
+	if GetOIDCEnabled() {
+		router.GET("/.well-known/openid-configuration", oidcDiscovery)
+		router.GET("/oidc/jwks", oidcJwks)
+		router.GET("/oidc/authorize", oidcAuthorize)
+		router.POST("/oidc/token", oidcToken)
+		router.GET("/oidc/userinfo", oidcUserinfo)
+	}
 
 	serverAddress := GetListenAddress() + ":" + strconv.Itoa(GetListenPort())
 	tlsEnabled := GetTlsEnabled()
@@ -86,6 +123,23 @@ func runGin() {
 		Handler: router,
 	}
 
+	// periodically delete expired cookies from the database and drop sessions revoked by other servers
+	// from the session cache
+	stopWorkers := make(chan struct{})
+	var workers sync.WaitGroup
+
+	workers.Add(2)
+
+	go func() {
+		defer workers.Done()
+		runCookieReaper(stopWorkers)
+	}()
+
+	go func() {
+		defer workers.Done()
+		runRevocationSubscriber(stopWorkers)
+	}()
+
 	// start the webserver in HTTP or HTTPS mode
 	go func() {
 		var err error = nil
@@ -104,7 +158,7 @@ func runGin() {
 	}()
 
 	// gracefully quit Gin server (https://gin-gonic.com/docs/examples/graceful-restart-or-stop/)
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	appLog.Println("Shutting down webserver...")
@@ -115,11 +169,18 @@ func runGin() {
 	if err := server.Shutdown(ctx); err != nil {
 		appLog.Fatalf("fatal error: could not shutdown server gracefully. %s\n", err)
 	}
+
+	close(stopWorkers)
+	workers.Wait()
+
+	if err := store.Close(); err != nil {
+		appLog.Printf("error: could not close the database. %s\n", err)
+	}
 }
 
 // addUser receives the username and plaintext password and adds the new user to the database.
 // If the password is empty, addUser will generate a password.
-func addUser(username string, password string, otp bool) {
+func addUser(username string, password string, otp bool, groups []string, email string, displayName string) {
 	if username == "" {
 		appLog.Fatalf("invalid username")
 	}
@@ -134,7 +195,7 @@ func addUser(username string, password string, otp bool) {
 
 		fmt.Printf("no password given, generated password for user '%s': '%s'\n", username, generatedPassword)
 
-		addUser(username, generatedPassword, otp)
+		addUser(username, generatedPassword, otp, groups, email, displayName)
 	} else if err := CheckPasswordRequirements(password); err != nil {
 		fmt.Printf("password does not meet minimum requirements: %s\n", err)
 		return
@@ -145,39 +206,20 @@ func addUser(username string, password string, otp bool) {
 		var encryptedOtpSecret []byte
 
 		if otp {
-			otpKey, err := totp.Generate(totp.GenerateOpts{
-				Issuer:      GetDomain(),
-				AccountName: username,
-			})
-
-			if err != nil {
-				appLog.Fatalf("could not create TOTP: %s", err)
-			}
-
-			fmt.Printf("TOTP secret key for user '%s': '%s'\n", username, otpKey.Secret())
-
-			// output TOTP url as QR code to stdout using libqrencode
-			output, err := exec.Command("sh", "-c", fmt.Sprintf("qrencode -t UTF8 '%s'", otpKey.URL())).Output()
-
-			fmt.Printf("TOTP URL for user '%s': '%s'\n", username, otpKey.URL())
-
-			if err != nil {
-				fmt.Println("install 'qrencode' library to display a QR code.")
-			} else {
-				fmt.Println(string(output))
-			}
-
-			// encrypt TOTP secret using user password for database storage
-			encryptedOtpSecret = Encrypt([]byte(otpKey.Secret()), password)
+			encryptedOtpSecret = enrollTotp(username, password)
 		}
 
 		user := User{
-			Username:  username,
-			Password:  encodedPasswordHash,
-			OtpSecret: encryptedOtpSecret,
+			Username:    username,
+			Password:    encodedPasswordHash,
+			OtpSecret:   encryptedOtpSecret,
+			Groups:      groups,
+			Email:       email,
+			DisplayName: displayName,
+			Created:     time.Now(),
 		}
 
-		err = CreateUser(&user)
+		err := CreateUser(&user)
 
 		if err != nil {
 			appLog.Fatalf("fatal error: could not save user to database: %s", err)
@@ -187,6 +229,35 @@ func addUser(username string, password string, otp bool) {
 	}
 }
 
+// enrollTotp generates a new TOTP secret key for the user with the given username and prints the secret key,
+// the TOTP URL and a QR code to stdout. Returns the secret key encrypted with the given password.
+func enrollTotp(username string, password string) []byte {
+	otpKey, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      GetDomain(),
+		AccountName: username,
+	})
+
+	if err != nil {
+		appLog.Fatalf("could not create TOTP: %s", err)
+	}
+
+	fmt.Printf("TOTP secret key for user '%s': '%s'\n", username, otpKey.Secret())
+
+	// output TOTP url as QR code to stdout using libqrencode
+	output, err := exec.Command("sh", "-c", fmt.Sprintf("qrencode -t UTF8 '%s'", otpKey.URL())).Output()
+
+	fmt.Printf("TOTP URL for user '%s': '%s'\n", username, otpKey.URL())
+
+	if err != nil {
+		fmt.Println("install 'qrencode' library to display a QR code.")
+	} else {
+		fmt.Println(string(output))
+	}
+
+	// encrypt TOTP secret using user password for database storage
+	return Encrypt([]byte(otpKey.Secret()), password)
+}
+
 // removeUser removes a user from the database.
 // TODO: don't delete associated user cookies if there is an existing LDAP user with the same username
 func removeUser(username string) {
@@ -205,26 +276,278 @@ func removeUser(username string) {
 	} else {
 		appLog.Printf("user associated cookies for username '%s' have been removed\n", username)
 	}
+
+	err = RevokeSignedSessionsByUsername(username)
+
+	if err != nil {
+		appLog.Fatalf("fatal error: could not revoke user associated signed sessions for username '%s': %s\n", username, err)
+	}
+
+	err = DeleteAPITokensByUsername(username)
+
+	if err != nil {
+		appLog.Fatalf("fatal error: could not remove user associated API tokens from database for username '%s': %s\n", username, err)
+	} else {
+		appLog.Printf("user associated API tokens for username '%s' have been removed\n", username)
+	}
+}
+
+// Identity describes the authenticated user of a request, regardless of the authentication method.
+type Identity struct {
+	Username string
+	Groups   []string
+	Email    string
+	Expires  time.Time // Expires :: zero value if the identity does not expire
 }
 
-// authenticate handles the /auth route. If a valid cookie is found in the request header, the
-// the response will be 200. If the cookie is invalid or expired, 401 is set as a response status.
+// authenticate handles the /auth route. If a valid cookie or API token is found in the request header and the
+// authorization rules grant access to the original request, the response will be 200.
+// If the cookie or API token is invalid or expired, 401 is set as a response status. If the user is authenticated
+// but not allowed to access the original request, 403 is set as a response status.
 func authenticate(c *gin.Context) {
-	token, err := c.Cookie("Nginx-Auth-Server-Token")
+	identity, err := authenticateRequest(c)
 
 	if err != nil {
+		if GetBasicAuthEnabled() {
+			c.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", GetBasicAuthRealm()))
+		}
+
 		c.AbortWithStatus(401)
 		return
 	}
 
-	if _, err = VerifyCookie(token); err != nil {
-		c.AbortWithStatus(401)
+	if !authorizeRequest(c, identity) {
+		return
+	}
+
+	setIdentityHeaders(c, identity)
+	c.Status(200)
+}
+
+// forwardAuthenticate handles the /auth/forward route for reverse proxies using the forward-auth model
+// (Traefik 'forwardAuth', Caddy 'forward_auth'). The original request is described by the
+// 'X-Forwarded-Method/Proto/Host/Uri' headers. Authenticated and authorized requests are answered with 200.
+// Unauthenticated browser requests are redirected (302) to the login page with the original URL as callback.
+// Requests containing an 'Authorization' header or using a method other than GET/HEAD receive 401 instead.
+// Requests of proxies that are not trusted (see TrustForwardedHeaders) are rejected with 403.
+// The 'X-Original-*' headers of the NGINX mode are ignored, since forward-auth proxies pass them through from the client.
+func forwardAuthenticate(c *gin.Context) {
+	if !TrustForwardedHeaders(c) {
+		c.AbortWithStatus(403)
+		appLog.Printf("warning: rejected forward-auth request of untrusted proxy '%s'\n", c.Request.RemoteAddr)
+		return
+	}
+
+	c.Set(forwardAuthContextKey, true)
+
+	identity, err := authenticateRequest(c)
+
+	if err != nil {
+		method := c.GetHeader("X-Forwarded-Method")
+
+		if c.GetHeader("Authorization") != "" || (method != "" && method != http.MethodGet && method != http.MethodHead) {
+			if GetBasicAuthEnabled() {
+				c.Header("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", GetBasicAuthRealm()))
+			}
+
+			c.AbortWithStatus(401)
+			return
+		}
+
+		c.Redirect(302, getForwardAuthLoginUrl(c))
+		c.Abort()
+		return
+	}
+
+	if !authorizeRequest(c, identity) {
 		return
+	}
+
+	setIdentityHeaders(c, identity)
+	c.Status(200)
+}
+
+// getForwardAuthLoginUrl builds the URL of the login page for the forward-auth mode using the
+// 'X-Forwarded-Proto/Host/Uri' headers. The original URL is attached as the 'callback' query parameter.
+// If no login URL is configured, the login page is expected at '/login' on the host of the original request.
+func getForwardAuthLoginUrl(c *gin.Context) string {
+	proto := c.GetHeader("X-Forwarded-Proto")
+
+	if proto == "" {
+		proto = "http"
+	}
+
+	uri := c.GetHeader("X-Forwarded-Uri")
+
+	if uri == "" {
+		uri = "/"
+	}
+
+	host := c.GetHeader("X-Forwarded-Host")
+	originalUrl := fmt.Sprintf("%s://%s%s", proto, host, uri)
+
+	loginUrl := GetForwardAuthLoginUrl()
+
+	if loginUrl == "" {
+		loginUrl = fmt.Sprintf("%s://%s/login", proto, host)
+	}
+
+	return fmt.Sprintf("%s?callback=%s", loginUrl, url.QueryEscape(originalUrl))
+}
+
+// authorizeRequest evaluates the authorization rules for the given Identity and the original request.
+// If the user is not allowed to access the original request, the request is aborted with 403 and false is returned.
+// If the original URI is missing or could not be parsed, the request is aborted with 400 and false is returned,
+// unless no rules are configured and the default policy is 'allow'.
+func authorizeRequest(c *gin.Context, identity *Identity) bool {
+	host, path, err := GetOriginalRequestFromContext(c)
+
+	if err != nil && (len(GetRules()) != 0 || GetAuthorizationDefaultPolicy() != "allow") {
+		c.AbortWithStatus(400)
+		authLog.Printf("user with username '%s' and client IP '%s' was denied access to host '%s': %s\n",
+			identity.Username, GetClientIpFromContext(c), host, err)
+		return false
+	}
+
+	if decision := EvaluateRules(identity.Username, identity.Groups, host, path); !decision.Allowed {
+		c.AbortWithStatus(403)
+		authLog.Printf("user with username '%s' and client IP '%s' was denied access to '%s%s': %s\n",
+			identity.Username, GetClientIpFromContext(c), host, path, decision.Reason)
+		return false
+	}
+
+	return true
+}
+
+// authenticateRequest authenticates the request using the API token in the 'Authorization: Bearer' header,
+// the credentials in the 'Authorization: Basic' header (if enabled) or, if the header is not set,
+// using the auth cookie. Returns the Identity of the authenticated user.
+// Returns nil and an error if the request could not be authenticated.
+func authenticateRequest(c *gin.Context) (*Identity, error) {
+	if username, password, ok := c.Request.BasicAuth(); ok && GetBasicAuthEnabled() {
+		return authenticateBasic(c, username, password)
+	}
+
+	if authorization := c.GetHeader("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		token, err := VerifyAPIToken(strings.TrimPrefix(authorization, "Bearer "))
+
+		if err != nil {
+			return nil, err
+		}
+
+		user, err := store.GetUser(token.Username)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if user == nil {
+			return nil, errors.New("error: user of API token does not exist")
+		} else if user.Disabled {
+			return nil, errors.New("error: user of API token is disabled")
+		}
+
+		return &Identity{
+			Username: user.Username,
+			Groups:   user.Groups,
+			Email:    GetUserEmail(user.Username),
+			Expires:  token.Expires,
+		}, nil
+	}
+
+	token, err := c.Cookie("Nginx-Auth-Server-Token")
+
+	if err != nil {
+		return nil, err
+	}
+
+	cookie, err := VerifyCookie(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// re-issue the cookie with the extended expiry if the session was renewed (sliding expiration)
+	// signed sessions are re-signed if they were renewed or signed with a retired signing key
+	renewed, err := RenewCookie(cookie)
+
+	if err != nil {
+		appLog.Printf("error: could not renew cookie of user with username '%s': %s\n", cookie.Username, err)
+	} else if renewed || NeedsResigning(cookie) {
+		if cookie.Signed {
+			token, err = SignSession(cookie)
+		}
+
+		if err != nil {
+			appLog.Printf("error: could not re-sign session of user with username '%s': %s\n", cookie.Username, err)
+		} else {
+			setAuthCookie(c, cookie, token)
+		}
+	}
+
+	return &Identity{
+		Username: cookie.Username,
+		Groups:   cookie.Groups,
+		Email:    cookie.Email,
+		Expires:  cookie.Expires,
+	}, nil
+}
+
+// authenticateBasic verifies the given HTTP Basic authentication credentials using the same local user and LDAP
+// validation as the login form. Users with enabled TOTP cannot authenticate using HTTP Basic authentication.
+// Verified credentials are cached to avoid the argon2 cost on every request.
+func authenticateBasic(c *gin.Context, username string, password string) (*Identity, error) {
+	if identity := GetBasicAuthFromCache(username, password); identity != nil {
+		return identity, nil
+	}
+
+	user, err := verifyCredentials(username, password, "")
+
+	if err != nil {
+		authLog.Printf("HTTP Basic authentication failed for user with username '%s' and client IP '%s': %s\n", username, GetClientIpFromContext(c), err)
+		return nil, err
+	}
+
+	identity := &Identity{Username: username}
+
+	if user != nil {
+		identity.Groups = user.Groups
 	} else {
-		c.Status(200)
+		identity.Groups = GetUserGroups(username)
+		identity.Email = GetUserEmail(username)
+	}
+
+	SaveBasicAuthToCache(username, password, identity)
+
+	return identity, nil
+}
+
+// setIdentityHeaders sets the configured identity headers (username, groups, email and session expiry)
+// of the given Identity on the response, so nginx can pass them to upstream applications.
+// Headers with an empty name in the configuration are omitted.
+func setIdentityHeaders(c *gin.Context, identity *Identity) {
+	if !GetHeadersEnabled() {
 		return
 	}
 
+	var expires string
+
+	if !identity.Expires.IsZero() {
+		expires = identity.Expires.UTC().Format(time.RFC3339)
+	}
+
+	headers := map[string]string{
+		GetHeadersUser():           identity.Username,
+		GetHeadersGroups():         strings.Join(identity.Groups, ","),
+		GetHeadersEmail():          identity.Email,
+		GetHeadersSessionExpires(): expires,
+	}
+
+	for name, value := range headers {
+		if name != "" && value != "" {
+			c.Header(name, value)
+		}
+	}
 }
 
 // login handles the /login route. If a valid cookie is found in the request header, the
@@ -243,6 +566,11 @@ func login(c *gin.Context) {
 		}
 	}
 
+	// users on secondary SSO domains log in on the primary domain
+	if redirectToSSOAuthorize(c) {
+		return
+	}
+
 	// attach all embedded CSS/JS files to the HTML template
 	cssFiles := GetFilenamesFromFS(staticFiles, "css")
 	jsFiles := GetFilenamesFromFS(staticFiles, "js")
@@ -267,8 +595,11 @@ func logout(c *gin.Context) {
 	}
 
 	if cookie, err := VerifyCookie(token); err == nil {
-		DeleteCookieFromCache(cookie)
-		err := DeleteCookie(cookie)
+		if cookie.Signed {
+			err = RevokeSignedSession(cookie)
+		} else {
+			err = DeleteCookie(cookie)
+		}
 
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, errors.New("could not delete user cookie from database"))
@@ -281,6 +612,7 @@ func logout(c *gin.Context) {
 				Value:    fmt.Sprintf("$username=%s,$value=%s", cookie.Username, cookie.Value),
 				Expires:  time.Now(),
 				Domain:   cookie.Domain,
+				Path:     "/",
 				HttpOnly: cookie.HttpOnly,
 				Secure:   cookie.Secure,
 			})
@@ -367,57 +699,126 @@ func processLoginForm(c *gin.Context) {
 		}
 	}
 
-	user := GetUserByUsername(data.Username)
+	user, err := verifyCredentials(data.Username, data.Password, data.TOTP)
 
-	if user == nil {
-		// if a user with the given username does not exist, check if LDAP authenticates
-		if ldapAuthenticate(data.Username, data.Password) {
-			createAndSetAuthCookie(c, data.Username)
-			c.Status(200)
-			authLog.Printf("LDAP user with username '%s' and client IP '%s' logged in successfully\n", data.Username, clientIp)
-		} else {
-			c.AbortWithStatus(401)
-			return
+	if errors.Is(err, errInvalidTotp) {
+		c.AbortWithStatusJSON(401, gin.H{"error": "invalid TOTP"})
+		return
+	} else if errors.Is(err, errInvalidPassword) {
+		c.AbortWithStatus(401)
+		authLog.Printf("invalid password for user with username '%s' and client IP '%s'\n", data.Username, clientIp)
+		return
+	} else if errors.Is(err, errUserDisabled) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		authLog.Printf("login of user with username '%s' and client IP '%s' was rejected, the user is disabled\n", data.Username, clientIp)
+		return
+	} else if err != nil {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	username := data.Username
+
+	if user != nil {
+		username = user.Username
+	}
+
+	evictedIds, ok := enforceSessionLimit(c, username, clientIp)
+
+	if !ok {
+		return
+	}
+
+	cookie := createAndSetAuthCookie(c, username, GetDomain(), getAuthMethod(user))
+	response := gin.H{"expires": cookie.Expires.UnixMilli()}
+
+	if len(evictedIds) != 0 {
+		response["evictedSessions"] = evictedIds
+	}
+
+	c.JSON(200, response)
+
+	if user != nil {
+		if err = RecordLogin(username, clientIp); err != nil {
+			appLog.Printf("error: could not record the login of user with username '%s'. %s\n", username, err)
 		}
+	}
+
+	if user == nil {
+		authLog.Printf("LDAP user with username '%s' and client IP '%s' logged in successfully\n", username, clientIp)
 	} else {
-		// if a user with the given username was found in the database, check password validity
-		if CompareHashAndPassword(user.Password, data.Password) != nil {
-			c.AbortWithStatus(401)
-			authLog.Printf("invalid password for user with username '%s' and client IP '%s'\n", data.Username, clientIp)
-			return
-		} else {
-			// if TOTP is enabled for the user, check the validity of the TOTP token input from the user
-			if len(user.OtpSecret) != 0 {
-				secret := Decrypt(user.OtpSecret, data.Password)
+		authLog.Printf("user with username '%s' and client IP '%s' logged in successfully\n", username, clientIp)
+	}
+}
 
-				tokenIsValid := totp.Validate(data.TOTP, string(secret))
+// enforceSessionLimit enforces the session limit of the user with the given username before a new session is
+// created (see EnforceSessionLimit). Returns the IDs of the evicted sessions and true if the session can be created.
+// Otherwise, the request is aborted with 403 (limit reached) or 500 and false is returned.
+func enforceSessionLimit(c *gin.Context, username string, clientIp string) ([]string, bool) {
+	evictedCookies, err := EnforceSessionLimit(username)
+
+	if errors.Is(err, errSessionLimitReached) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "session limit reached"})
+		authLog.Printf("login of user with username '%s' and client IP '%s' was rejected, the session limit is reached\n", username, clientIp)
+		return nil, false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not enforce the session limit"})
+		appLog.Printf("error: could not enforce the session limit of user with username '%s'. %s\n", username, err)
+		return nil, false
+	}
 
-				if !tokenIsValid {
-					c.AbortWithStatusJSON(401, gin.H{"error": "invalid TOTP"})
-					return
-				}
-			}
+	var evictedIds []string
 
-			cookie := createAndSetAuthCookie(c, user.Username)
-			c.JSON(200, gin.H{"expires": cookie.Expires.UnixMilli()})
-			authLog.Printf("user with username '%s' and client IP '%s' logged in successfully\n", data.Username, clientIp)
-		}
+	for _, evictedCookie := range evictedCookies {
+		evictedIds = append(evictedIds, evictedCookie.ID)
+	}
+
+	if len(evictedIds) != 0 {
+		authLog.Printf("the session limit of user with username '%s' is reached, evicted sessions with IDs '%s'\n",
+			username, strings.Join(evictedIds, "', '"))
 	}
+
+	return evictedIds, true
 }
 
-// createAndSetAuthCookie sets a new cookie for the given gin.Context and username and saves it to the database.
-// This function is called after the user credentials have been verified.
-func createAndSetAuthCookie(c *gin.Context, username string) Cookie {
+// createAndSetAuthCookie sets a new cookie for the given gin.Context, username and cookie domain and saves it
+// to the database. This function is called after the user credentials (or a SSO code) have been verified.
+func createAndSetAuthCookie(c *gin.Context, username string, domain string, authMethod string) Cookie {
 	plainCookieValue := GeneratePassword(96, 25, 35)
+	now := time.Now()
 
 	cookie := Cookie{
-		Name:     "Nginx-Auth-Server-Token",
-		Value:    GenerateHash(plainCookieValue),
-		Expires:  time.Now().AddDate(0, 0, GetCookieLifetime()),
-		Domain:   GetDomain(),
-		Username: username,
-		HttpOnly: true,
-		Secure:   GetCookieSecure(),
+		ID:         GenerateSessionID(),
+		Name:       "Nginx-Auth-Server-Token",
+		Value:      GenerateHash(plainCookieValue),
+		Expires:    now.AddDate(0, 0, GetCookieLifetime()),
+		Created:    now,
+		LastSeen:   now,
+		Domain:     domain,
+		Username:   username,
+		Groups:     GetUserGroups(username),
+		Email:      GetUserEmail(username),
+		ClientIP:   GetClientIpFromContext(c),
+		UserAgent:  c.Request.UserAgent(),
+		AuthMethod: authMethod,
+		HttpOnly:   true,
+		Secure:     GetCookieSecure(),
+	}
+
+	// signed sessions are not saved to the database
+	if GetCookieMode() == "signed" {
+		cookie.Value = ""
+		cookie.Signed = true
+
+		token, err := SignSession(&cookie)
+
+		if err != nil {
+			appLog.Fatalf("fatal error: could not sign the session: %s", err)
+		}
+
+		setAuthCookie(c, &cookie, token)
+
+		return cookie
 	}
 
 	err := SaveCookie(cookie)
@@ -426,18 +827,26 @@ func createAndSetAuthCookie(c *gin.Context, username string) Cookie {
 		appLog.Fatalf("fatal error: could not save the cookie to the database: %s", err)
 	}
 
+	setAuthCookie(c, &cookie, fmt.Sprintf("$username=%s,$value=%s", username, plainCookieValue))
+
+	SaveCookieToCache(&cookie, plainCookieValue)
+
+	return cookie
+}
+
+// setAuthCookie sets the 'Set-Cookie' header for the given cookie and token on the response.
+// Example for token param: '$username=foo,$value=kC6......LOh'.
+func setAuthCookie(c *gin.Context, cookie *Cookie, token string) {
+	// without a path, browsers scope the cookie to the directory of the route that set it (e.g. /account/)
 	http.SetCookie(c.Writer, &http.Cookie{
 		Name:     cookie.Name,
-		Value:    fmt.Sprintf("$username=%s,$value=%s", username, plainCookieValue),
+		Value:    token,
 		Expires:  cookie.Expires,
 		Domain:   cookie.Domain,
+		Path:     "/",
 		HttpOnly: cookie.HttpOnly,
 		Secure:   cookie.Secure,
 	})
-
-	SaveCookieToCache(&cookie, plainCookieValue)
-
-	return cookie
 }
 
 // whoami handles the /whoami route. If the request contains a valid cookie,
@@ -461,3 +870,123 @@ func whoami(c *gin.Context) {
 		return
 	}
 }
+
+// listSessions handles the GET /sessions route and returns the sessions of the authenticated user (formatted as JSON).
+// Administrators can list the sessions of another user using the 'username' query param.
+// Signed sessions are not stored in the database and are not listed.
+func listSessions(c *gin.Context) {
+	identity, err := authenticateRequest(c)
+
+	if err != nil {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	username := identity.Username
+
+	if queryUsername := c.Query("username"); queryUsername != "" && queryUsername != username {
+		if !isAdmin(identity) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		username = queryUsername
+	}
+
+	cookies, err := store.GetSessionsByUsername(username)
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not read sessions from database"})
+		appLog.Printf("error: could not read sessions of user with username '%s' from database. %s\n", username, err)
+		return
+	}
+
+	// the argon2 hash of the cookie value is not returned
+	sessions := make([]gin.H, 0, len(cookies))
+
+	for _, cookie := range cookies {
+		sessions = append(sessions, gin.H{
+			"id":         cookie.ID,
+			"username":   cookie.Username,
+			"created":    cookie.Created,
+			"lastSeen":   cookie.LastSeen,
+			"expires":    cookie.Expires,
+			"clientIp":   cookie.ClientIP,
+			"userAgent":  cookie.UserAgent,
+			"authMethod": cookie.AuthMethod,
+		})
+	}
+
+	c.JSON(200, gin.H{"sessions": sessions})
+}
+
+// revokeSession handles the DELETE /sessions/:id route and deletes the session with the given ID.
+// Users can revoke their own sessions, administrators can revoke the sessions of all users.
+// Returns 404 if the session does not exist or belongs to another user.
+// Signed sessions are not stored in the database, so their owner is unknown. In signed mode, administrators revoke
+// the signed session with the given ID if no session with the ID exists, other users receive 400.
+func revokeSession(c *gin.Context) {
+	identity, err := authenticateRequest(c)
+	clientIp := GetClientIpFromContext(c)
+
+	if err != nil {
+		c.AbortWithStatus(401)
+		return
+	}
+
+	cookie, err := GetCookieByID(c.Param("id"))
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not read sessions from database"})
+		appLog.Printf("error: could not read sessions from database. %s\n", err)
+		return
+	}
+
+	if cookie == nil && GetCookieMode() == "signed" {
+		revokeSignedSession(c, identity, clientIp)
+		return
+	}
+
+	if cookie == nil || (cookie.Username != identity.Username && !isAdmin(identity)) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if _, err = RevokeCookieByID(cookie.ID); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not revoke session"})
+		appLog.Printf("error: could not revoke session with ID '%s'. %s\n", cookie.ID, err)
+		return
+	}
+
+	authLog.Printf("user with username '%s' and client IP '%s' revoked the session with ID '%s' of user with username '%s'\n",
+		identity.Username, clientIp, cookie.ID, cookie.Username)
+
+	c.Status(http.StatusNoContent)
+}
+
+// revokeSignedSession revokes the signed session with the ID of the DELETE /sessions/:id route if the given Identity
+// is an administrator. Other users cannot revoke signed sessions by ID and receive 400.
+func revokeSignedSession(c *gin.Context, identity *Identity, clientIp string) {
+	if !isAdmin(identity) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "signed sessions can only be revoked by administrators, use /logout to end the current session"})
+		return
+	}
+
+	if err := RevokeSignedSessionByID(c.Param("id")); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not revoke session"})
+		appLog.Printf("error: could not revoke signed session with ID '%s'. %s\n", c.Param("id"), err)
+		return
+	}
+
+	authLog.Printf("user with username '%s' and client IP '%s' revoked the signed session with ID '%s'\n",
+		identity.Username, clientIp, c.Param("id"))
+
+	c.Status(http.StatusNoContent)
+}
+
+// isAdmin returns true if the given Identity is a member of the configured admin group.
+func isAdmin(identity *Identity) bool {
+	adminGroup := GetAuthorizationAdminGroup()
+
+	return adminGroup != "" && containsString(identity.Groups, adminGroup)
+}
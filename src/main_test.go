@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestForwardAuthenticateIgnoresOriginalHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previousConfig := *config
+	previousStore := store
+	store = NewMemoryStore()
+
+	t.Cleanup(func() {
+		*config = previousConfig
+		store = previousStore
+	})
+
+	config.ForwardAuth.Enabled = true
+
+	newTestRules(t, "deny",
+		Rule{Name: "public", Path: "/public/*"},
+		Rule{Name: "admin", Path: "/admin/*", AllowUsers: []string{"bob"}},
+	)
+
+	if err := store.SaveUser(User{Username: "alice"}); err != nil {
+		t.Fatalf("SaveUser: %s", err)
+	}
+
+	token, _, err := CreateAPIToken("alice", "test", 0)
+
+	if err != nil {
+		t.Fatalf("CreateAPIToken: %s", err)
+	}
+
+	router := gin.New()
+	router.GET("/auth/forward", forwardAuthenticate)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{
+			name:    "forwarded public path",
+			headers: map[string]string{"X-Forwarded-Host": "app.example.com", "X-Forwarded-Uri": "/public/index.html"},
+			status:  http.StatusOK,
+		},
+		{
+			name:    "forwarded admin path",
+			headers: map[string]string{"X-Forwarded-Host": "app.example.com", "X-Forwarded-Uri": "/admin/index.html"},
+			status:  http.StatusForbidden,
+		},
+		{
+			name: "spoofed original headers",
+			headers: map[string]string{
+				"X-Forwarded-Host":       "app.example.com",
+				"X-Forwarded-Uri":        "/admin/index.html",
+				"X-Original-Host":        "app.example.com",
+				"X-Original-URI":         "/public/index.html",
+				"X-Original-Remote-Addr": "10.0.0.1",
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			name:    "original headers only",
+			headers: map[string]string{"X-Original-Host": "app.example.com", "X-Original-URI": "/public/index.html"},
+			status:  http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/auth/forward", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+
+		for name, value := range test.headers {
+			request.Header.Set(name, value)
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, recorder.Code, test.status)
+		}
+	}
+}
+
+func TestGetClientIpFromContextOfForwardAuthRequest(t *testing.T) {
+	previousConfig := *config
+
+	t.Cleanup(func() {
+		*config = previousConfig
+	})
+
+	config.ForwardAuth.Enabled = true
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/auth/forward", nil)
+	c.Request.Header.Set("X-Original-Remote-Addr", "10.0.0.1")
+	c.Request.Header.Set("X-Forwarded-For", "192.0.2.10, 192.0.2.20")
+
+	if clientIp := GetClientIpFromContext(c); clientIp != "10.0.0.1" {
+		t.Errorf("NGINX request: got client IP '%s', want '10.0.0.1'", clientIp)
+	}
+
+	c.Set(forwardAuthContextKey, true)
+
+	if clientIp := GetClientIpFromContext(c); clientIp != "192.0.2.10" {
+		t.Errorf("forward-auth request: got client IP '%s', want '192.0.2.10'", clientIp)
+	}
+}
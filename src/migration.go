@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// This file implements the versioning of the database schema. The schema version is saved in the 'meta' bucket and
+// equals the number of applied migrations. Databases created before the schema version was introduced have no
+// 'meta' bucket and are treated as version 0. The server applies pending migrations at startup, CLI commands
+// refuse to operate on an outdated database until it was migrated with 'db migrate'.
+
+const (
+	// metaBucketName is the name of the bucket that contains the metadata of the database
+	metaBucketName = "meta"
+
+	// schemaVersionKey is the key of the schema version in the metadata bucket
+	schemaVersionKey = "schemaVersion"
+)
+
+// migration is a change of the database schema.
+type migration struct {
+	description string
+	migrate     func(tx *bolt.Tx) error
+}
+
+// migrations contains all migrations in the order they are applied. The schema version of a database is the number
+// of applied migrations, therefore migrations must only be appended to this list, never removed or reordered.
+var migrations = []migration{
+	{
+		description: "assign IDs to sessions created before sessions had IDs",
+		migrate:     migrateSessionIDs,
+	},
+	{
+		description: "index sessions by username",
+		migrate:     migrateSessionIndex,
+	},
+}
+
+// errDatabaseTooNew is returned if the database was written by a newer version of the application.
+var errDatabaseTooNew = errors.New("the database was written by a newer version of nginx-auth-server")
+
+// getCurrentSchemaVersion returns the schema version of this version of the application.
+func getCurrentSchemaVersion() int {
+	return len(migrations)
+}
+
+// getSchemaVersion returns the schema version of the database. Returns 0 if the database has no schema version.
+func getSchemaVersion(tx *bolt.Tx) (int, error) {
+	bucket := tx.Bucket([]byte(metaBucketName))
+
+	if bucket == nil {
+		return 0, nil
+	}
+
+	value := bucket.Get([]byte(schemaVersionKey))
+
+	if value == nil {
+		return 0, nil
+	}
+
+	version, err := strconv.Atoi(string(value))
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version '%s'. %s", value, err)
+	}
+
+	return version, nil
+}
+
+// setSchemaVersion saves the given schema version to the database.
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(metaBucketName))
+
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(schemaVersionKey), []byte(strconv.Itoa(version)))
+}
+
+// migrateDatabase applies all pending migrations to the given database. Every migration is applied in its own
+// transaction together with the new schema version. Returns the schema version before and after the migration.
+// Returns errDatabaseTooNew if the database was written by a newer version of the application.
+func migrateDatabase(db *bolt.DB) (int, int, error) {
+	var from int
+
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		from, err = getSchemaVersion(tx)
+
+		return err
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if from > getCurrentSchemaVersion() {
+		return from, from, fmt.Errorf("%w (schema version %d, supported version %d)", errDatabaseTooNew, from, getCurrentSchemaVersion())
+	}
+
+	for version := from; version < getCurrentSchemaVersion(); version++ {
+		appLog.Printf("migrating database schema to version %d: %s\n", version+1, migrations[version].description)
+
+		err = db.Update(func(tx *bolt.Tx) error {
+			if err := migrations[version].migrate(tx); err != nil {
+				return err
+			}
+
+			return setSchemaVersion(tx, version+1)
+		})
+
+		if err != nil {
+			return from, version, fmt.Errorf("migration to schema version %d failed. %s", version+1, err)
+		}
+	}
+
+	return from, getCurrentSchemaVersion(), nil
+}
+
+// prepareDatabase checks the schema version of a database that is opened by a CLI command. A new, empty database
+// is initialized with the current schema version. Returns an error if the database is outdated or was written by
+// a newer version of the application.
+func prepareDatabase(db *bolt.DB) error {
+	var version int
+	empty := false
+
+	err := db.View(func(tx *bolt.Tx) error {
+		name, _ := tx.Cursor().First()
+		empty = name == nil
+
+		var err error
+		version, err = getSchemaVersion(tx)
+
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if version > getCurrentSchemaVersion() {
+		return fmt.Errorf("%w (schema version %d, supported version %d)", errDatabaseTooNew, version, getCurrentSchemaVersion())
+	}
+
+	if version == getCurrentSchemaVersion() {
+		return nil
+	}
+
+	if empty {
+		// empty databases are migrated by the first read-write operation
+		if db.IsReadOnly() {
+			return nil
+		}
+
+		_, _, err = migrateDatabase(db)
+		return err
+	}
+
+	return fmt.Errorf("the database schema (version %d) is outdated, run 'nginx-auth-server db migrate' or start the server to migrate it to version %d", version, getCurrentSchemaVersion())
+}
+
+// MigrateDatabase applies all pending migrations to the database. Returns the schema version before and after
+// the migration.
+func MigrateDatabase() (int, int, error) {
+	s, err := getBoltStore()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var from, to int
+
+	err = s.withUncheckedDatabase(false, func(db *bolt.DB) error {
+		var err error
+		from, to, err = migrateDatabase(db)
+
+		return err
+	})
+
+	return from, to, err
+}
+
+// migrateSessionIDs assigns a random ID to all sessions without an ID. These sessions were created before
+// sessions had IDs and could not be revoked individually.
+func migrateSessionIDs(tx *bolt.Tx) error {
+	bucket := tx.Bucket([]byte("cookies"))
+
+	if bucket == nil {
+		return nil
+	}
+
+	var cookies []Cookie
+
+	err := bucket.ForEach(func(key, value []byte) error {
+		cookie := Cookie{}
+
+		if err := unmarshalValue("cookies", key, value, &cookie); err != nil {
+			return err
+		}
+
+		if cookie.ID == "" {
+			cookie.ID = GenerateSessionID()
+			cookies = append(cookies, cookie)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// the bucket must not be modified while iterating over it
+	for _, cookie := range cookies {
+		buffer, err := marshalValue("cookies", []byte(cookie.Value), cookie)
+
+		if err != nil {
+			return err
+		}
+
+		if err = bucket.Put([]byte(cookie.Value), buffer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateSessionIndex (re)builds the username index of the sessions (see sessionIndexBucketName).
+func migrateSessionIndex(tx *bolt.Tx) error {
+	if err := tx.DeleteBucket([]byte(sessionIndexBucketName)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+		return err
+	}
+
+	if _, err := tx.CreateBucket([]byte(sessionIndexBucketName)); err != nil {
+		return err
+	}
+
+	bucket := tx.Bucket([]byte("cookies"))
+
+	if bucket == nil {
+		return nil
+	}
+
+	return bucket.ForEach(func(key, value []byte) error {
+		cookie := Cookie{}
+
+		if err := unmarshalValue("cookies", key, value, &cookie); err != nil {
+			return err
+		}
+
+		index, err := createSessionIndex(tx, cookie.Username)
+
+		if err != nil {
+			return err
+		}
+
+		return index.Put(key, []byte{})
+	})
+}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// This file implements a minimal OpenID Connect (OIDC) identity provider on top of the local user database and
+// LDAP. It supports the authorization code flow with PKCE (S256), discovery, a JWKS endpoint and RS256-signed
+// ID tokens. PKCE is required for public clients and optional for confidential clients unless 'require_pkce' is set. The /login route is reused as the interactive step. Clients are registered in the config.ini using
+// sections prefixed with 'OIDCClient.' (e.g. [OIDCClient.grafana]).
+// Refer to https://openid.net/specs/openid-connect-core-1_0.html.
+
+// OIDCClient :: [OIDCClient.<client_id>]-Section of .ini
+type OIDCClient struct {
+	ID           string   `ini:"-"`
+	Secret       string   `ini:"secret"` // Secret :: empty for public clients
+	RedirectURIs []string `ini:"redirect_uris"`
+	RequirePKCE  bool     `ini:"require_pkce"` // RequirePKCE :: PKCE is always required for public clients
+}
+
+// OIDCCode is the structure for the database representation of a one-time OIDC authorization code
+type OIDCCode struct {
+	ClientID      string    `json:"clientId"`
+	RedirectURI   string    `json:"redirectUri"`
+	Scope         string    `json:"scope"`
+	Nonce         string    `json:"nonce"`
+	CodeChallenge string    `json:"codeChallenge"`
+	Username      string    `json:"username"`
+	Name          string    `json:"name"` // Name :: display name of the user
+	Groups        []string  `json:"groups"`
+	Email         string    `json:"email"`
+	Expires       time.Time `json:"expires"`
+}
+
+// OIDCClaims are the claims of the ID tokens and access tokens issued by the OIDC provider.
+type OIDCClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          string   `json:"aud"`
+	Expires           int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce,omitempty"`
+	Scope             string   `json:"scope,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+const (
+	oidcSigningKeyFileName = "oidc-signing-key.pem"
+
+	// oidcIDTokenType is the 'typ' header of ID tokens
+	oidcIDTokenType = "JWT"
+
+	// oidcAccessTokenType is the 'typ' header of access tokens (RFC 9068). Access tokens are only accepted by the
+	// userinfo endpoint, which rejects ID tokens regardless of their audience.
+	oidcAccessTokenType = "at+jwt"
+)
+
+var (
+	oidcSigningKey      *rsa.PrivateKey
+	oidcSigningKeyMutex sync.Mutex
+)
+
+// oidcDiscovery handles the /.well-known/openid-configuration route.
+func oidcDiscovery(c *gin.Context) {
+	issuer := GetOIDCIssuer()
+
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oidc/authorize",
+		"token_endpoint":                        issuer + "/oidc/token",
+		"userinfo_endpoint":                     issuer + "/oidc/userinfo",
+		"jwks_uri":                              issuer + "/oidc/jwks",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile", "email", "groups"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"iss", "sub", "aud", "exp", "iat", "nonce", "preferred_username", "name", "email", "groups"},
+	})
+}
+
+// oidcJwks handles the /oidc/jwks route and returns the public signing key as a JSON Web Key Set.
+func oidcJwks(c *gin.Context) {
+	key, err := getOIDCSigningKey()
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"keys": []gin.H{
+			{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": getOIDCKeyID(&key.PublicKey),
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			},
+		},
+	})
+}
+
+// oidcAuthorize handles the /oidc/authorize route. If the user is authenticated, a one-time authorization code
+// is issued and the user is redirected to the redirect URI of the client. If the user is not authenticated,
+// the user is redirected to the login page, which redirects back after a successful login.
+func oidcAuthorize(c *gin.Context) {
+	client := getOIDCClient(c.Query("client_id"))
+
+	if client == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_client"})
+		return
+	}
+
+	redirectUri := c.Query("redirect_uri")
+
+	if !containsString(client.RedirectURIs, redirectUri) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "redirect_uri is not registered"})
+		return
+	}
+
+	scope := c.Query("scope")
+
+	if c.Query("response_type") != "code" {
+		redirectOIDCError(c, redirectUri, "unsupported_response_type")
+		return
+	}
+
+	if !containsString(strings.Fields(scope), "openid") {
+		redirectOIDCError(c, redirectUri, "invalid_scope")
+		return
+	}
+
+	codeChallenge := c.Query("code_challenge")
+
+	if (codeChallenge == "" && client.requiresPKCE()) || (codeChallenge != "" && c.Query("code_challenge_method") != "S256") {
+		redirectOIDCError(c, redirectUri, "invalid_request")
+		return
+	}
+
+	token, err := c.Cookie("Nginx-Auth-Server-Token")
+
+	var cookie *Cookie
+
+	if err == nil {
+		cookie, err = VerifyCookie(token)
+	}
+
+	if err != nil {
+		c.Redirect(http.StatusFound, "/login?callback="+url.QueryEscape(c.Request.URL.RequestURI()))
+		return
+	}
+
+	code, err := CreateOIDCCode(OIDCCode{
+		ClientID:      client.ID,
+		RedirectURI:   redirectUri,
+		Scope:         scope,
+		Nonce:         c.Query("nonce"),
+		CodeChallenge: codeChallenge,
+		Username:      cookie.Username,
+		Name:          GetUserDisplayName(cookie.Username),
+		Groups:        cookie.Groups,
+		Email:         cookie.Email,
+		Expires:       time.Now().Add(time.Duration(GetOIDCCodeLifetime()) * time.Second),
+	})
+
+	if err != nil {
+		appLog.Printf("error: could not create OIDC authorization code for user with username '%s': %s\n", cookie.Username, err)
+		redirectOIDCError(c, redirectUri, "server_error")
+		return
+	}
+
+	query := url.Values{"code": {code}}
+
+	if state := c.Query("state"); state != "" {
+		query.Set("state", state)
+	}
+
+	authLog.Printf("user with username '%s' and client IP '%s' authorized OIDC client '%s'\n", cookie.Username, GetClientIpFromContext(c), client.ID)
+
+	c.Redirect(http.StatusFound, appendQuery(redirectUri, query))
+}
+
+// oidcToken handles the POST /oidc/token route. The authorization code is exchanged for an ID token and an
+// access token after the client credentials (confidential clients) and the PKCE code verifier (if the authorization
+// request contained a code challenge) were verified.
+func oidcToken(c *gin.Context) {
+	if c.PostForm("grant_type") != "authorization_code" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unsupported_grant_type"})
+		return
+	}
+
+	clientId, clientSecret, ok := c.Request.BasicAuth()
+
+	if !ok {
+		clientId = c.PostForm("client_id")
+		clientSecret = c.PostForm("client_secret")
+	}
+
+	client := getOIDCClient(clientId)
+
+	if client == nil || (client.Secret != "" && subtle.ConstantTimeCompare([]byte(client.Secret), []byte(clientSecret)) != 1) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		return
+	}
+
+	code, err := ConsumeOIDCCode(c.PostForm("code"))
+
+	if err != nil || code.ClientID != client.ID || code.RedirectURI != c.PostForm("redirect_uri") {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+		return
+	}
+
+	if code.CodeChallenge != "" {
+		verifierHash := sha256.Sum256([]byte(c.PostForm("code_verifier")))
+
+		if base64.RawURLEncoding.EncodeToString(verifierHash[:]) != code.CodeChallenge {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid_grant", "error_description": "invalid code_verifier"})
+			return
+		}
+	}
+
+	now := time.Now()
+	lifetime := time.Duration(GetOIDCTokenLifetime()) * time.Second
+	scopes := strings.Fields(code.Scope)
+
+	claims := OIDCClaims{
+		Issuer:            GetOIDCIssuer(),
+		Subject:           code.Username,
+		Audience:          client.ID,
+		Expires:           now.Add(lifetime).Unix(),
+		IssuedAt:          now.Unix(),
+		Nonce:             code.Nonce,
+		PreferredUsername: code.Username,
+	}
+
+	if containsString(scopes, "profile") {
+		claims.Name = code.Name
+	}
+
+	if containsString(scopes, "email") {
+		claims.Email = code.Email
+	}
+
+	if containsString(scopes, "groups") {
+		claims.Groups = code.Groups
+	}
+
+	idToken, err := signOIDCToken(claims, oidcIDTokenType)
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	claims.Audience = getOIDCUserinfoUrl()
+	claims.Nonce = ""
+	claims.Scope = code.Scope
+
+	accessToken, err := signOIDCToken(claims, oidcAccessTokenType)
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(lifetime.Seconds()),
+		"id_token":     idToken,
+		"scope":        code.Scope,
+	})
+}
+
+// oidcUserinfo handles the /oidc/userinfo route and returns the claims of the access token
+// in the 'Authorization: Bearer' header.
+func oidcUserinfo(c *gin.Context) {
+	claims, err := verifyOIDCToken(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "), oidcAccessTokenType)
+
+	if err != nil || claims.Audience != getOIDCUserinfoUrl() {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sub":                claims.Subject,
+		"preferred_username": claims.PreferredUsername,
+		"name":               claims.Name,
+		"email":              claims.Email,
+		"groups":             claims.Groups,
+	})
+}
+
+// getOIDCUserinfoUrl returns the URL of the userinfo endpoint, which is the audience of access tokens.
+func getOIDCUserinfoUrl() string {
+	return GetOIDCIssuer() + "/oidc/userinfo"
+}
+
+// requiresPKCE returns true if the client has to use PKCE. Public clients cannot authenticate at the token endpoint,
+// so they always have to use PKCE.
+func (client *OIDCClient) requiresPKCE() bool {
+	return client.Secret == "" || client.RequirePKCE
+}
+
+// redirectOIDCError redirects the user to the given redirect URI with the given OAuth 2.0 error code.
+func redirectOIDCError(c *gin.Context, redirectUri string, errorCode string) {
+	query := url.Values{"error": {errorCode}}
+
+	if state := c.Query("state"); state != "" {
+		query.Set("state", state)
+	}
+
+	c.Redirect(http.StatusFound, appendQuery(redirectUri, query))
+}
+
+// appendQuery appends the given query params to the given URL.
+func appendQuery(rawUrl string, query url.Values) string {
+	if strings.Contains(rawUrl, "?") {
+		return rawUrl + "&" + query.Encode()
+	}
+
+	return rawUrl + "?" + query.Encode()
+}
+
+// getOIDCClient returns the registered OIDC client with the given client ID. Returns nil if the OIDC provider
+// is disabled or no client with the given ID is registered.
+func getOIDCClient(clientId string) *OIDCClient {
+	if !GetOIDCEnabled() {
+		return nil
+	}
+
+	for _, client := range GetOIDCClients() {
+		if clientId != "" && client.ID == clientId {
+			return &client
+		}
+	}
+
+	return nil
+}
+
+// CreateOIDCCode saves the given authorization code data to the database and returns the plaintext
+// authorization code. Only the SHA-256 hash of the code is saved.
+func CreateOIDCCode(code OIDCCode) (string, error) {
+	random, err := GenerateRandomBytes(32)
+
+	if err != nil {
+		return "", err
+	}
+
+	plainCode := hex.EncodeToString(random)
+
+	err = store.Update(func(tx Tx) error {
+		bucket := tx.Bucket("oidcCodes")
+
+		// remove expired codes that were never exchanged
+		var expiredKeys [][]byte
+
+		_ = bucket.ForEach(func(key, value []byte) error {
+			expiredCode := OIDCCode{}
+
+			if json.Unmarshal(value, &expiredCode) != nil || expiredCode.Expires.Before(time.Now()) {
+				expiredKeys = append(expiredKeys, key)
+			}
+
+			return nil
+		})
+
+		for _, key := range expiredKeys {
+			if err = bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		buffer, err := json.Marshal(code)
+
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(HashSHA256(plainCode)), buffer)
+	})
+
+	return plainCode, err
+}
+
+// ConsumeOIDCCode looks up the given plaintext authorization code, deletes it from the database and returns it.
+// Returns nil and an error if the code was not found or is expired.
+func ConsumeOIDCCode(plainCode string) (*OIDCCode, error) {
+	if plainCode == "" {
+		return nil, errors.New("error: no authorization code provided")
+	}
+
+	var code *OIDCCode
+
+	err := store.Update(func(tx Tx) error {
+		bucket := tx.Bucket("oidcCodes")
+
+		if bucket == nil {
+			return errors.New("error: authorization code not found")
+		}
+
+		key := []byte(HashSHA256(plainCode))
+		value := bucket.Get(key)
+
+		if value == nil {
+			return errors.New("error: authorization code not found")
+		}
+
+		if err := json.Unmarshal(value, &code); err != nil {
+			return err
+		}
+
+		// the code can only be used once
+		return bucket.Delete(key)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if code.Expires.Before(time.Now()) {
+		return nil, errors.New("error: authorization code is expired")
+	}
+
+	return code, nil
+}
+
+// signOIDCToken signs the given claims using the OIDC signing key and returns the RS256 JSON Web Token with the
+// given 'typ' header (oidcIDTokenType or oidcAccessTokenType).
+func signOIDCToken(claims OIDCClaims, tokenType string) (string, error) {
+	key, err := getOIDCSigningKey()
+
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": tokenType, "kid": getOIDCKeyID(&key.PublicKey)})
+
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+
+	if err != nil {
+		return "", err
+	}
+
+	unsignedToken := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(unsignedToken))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+
+	if err != nil {
+		return "", err
+	}
+
+	return unsignedToken + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verifyOIDCToken verifies the signature, the 'typ' header, the issuer and the expiry of the given JSON Web Token
+// and returns its claims. Returns nil and an error if the token is invalid or not of the given type.
+func verifyOIDCToken(token string, tokenType string) (*OIDCClaims, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, errors.New("token does not match syntax")
+	}
+
+	key, err := getOIDCSigningKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, errors.New("token does not match syntax")
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	if err = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+		return nil, errors.New("error: invalid signature")
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+	if err != nil {
+		return nil, errors.New("token does not match syntax")
+	}
+
+	var headerFields map[string]string
+
+	if err = json.Unmarshal(header, &headerFields); err != nil {
+		return nil, errors.New("token does not match syntax")
+	}
+
+	if headerFields["typ"] != tokenType {
+		return nil, fmt.Errorf("error: token is not of type '%s'", tokenType)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return nil, errors.New("token does not match syntax")
+	}
+
+	var claims OIDCClaims
+
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("token does not match syntax")
+	}
+
+	if claims.Issuer != GetOIDCIssuer() || time.Unix(claims.Expires, 0).Before(time.Now()) {
+		return nil, errors.New("error: token is expired or was issued by another issuer")
+	}
+
+	return &claims, nil
+}
+
+// getOIDCSigningKey returns the RSA key used to sign OIDC tokens. The key is loaded from the configured key file
+// ('[OIDC] signing_key_file') or from the directory of the database and generated upon first use.
+func getOIDCSigningKey() (*rsa.PrivateKey, error) {
+	oidcSigningKeyMutex.Lock()
+	defer oidcSigningKeyMutex.Unlock()
+
+	if oidcSigningKey != nil {
+		return oidcSigningKey, nil
+	}
+
+	keyFilePath := GetOIDCSigningKeyFile()
+
+	if keyFilePath == "" {
+		keyFilePath = filepath.Join(filepath.Dir(databaseFilePath), oidcSigningKeyFileName)
+	}
+
+	if keyPem, err := os.ReadFile(keyFilePath); err == nil {
+		block, _ := pem.Decode(keyPem)
+
+		if block == nil {
+			return nil, fmt.Errorf("could not decode OIDC signing key at '%s'", keyFilePath)
+		}
+
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not parse OIDC signing key at '%s': %s", keyFilePath, err)
+		}
+
+		oidcSigningKey = key
+
+		return oidcSigningKey, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	if err = os.WriteFile(keyFilePath, keyPem, 0600); err != nil {
+		return nil, fmt.Errorf("could not save OIDC signing key to '%s': %s", keyFilePath, err)
+	}
+
+	appLog.Printf("generated new OIDC signing key at '%s'\n", keyFilePath)
+
+	oidcSigningKey = key
+
+	return oidcSigningKey, nil
+}
+
+// getOIDCKeyID returns the key ID (first 8 bytes of the SHA-256 hash of the public key, hex encoded)
+// of the given public key.
+func getOIDCKeyID(publicKey *rsa.PublicKey) string {
+	hash := sha256.Sum256(x509.MarshalPKCS1PublicKey(publicKey))
+
+	return hex.EncodeToString(hash[:8])
+}
+
+// containsString returns true if the given list contains the given value.
+func containsString(list []string, value string) bool {
+	for _, element := range list {
+		if strings.TrimSpace(element) == value {
+			return true
+		}
+	}
+
+	return false
+}
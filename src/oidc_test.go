@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previousConfig := *config
+	previousStore := store
+	store = NewMemoryStore()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+
+	previousKey := oidcSigningKey
+	oidcSigningKey = key
+
+	t.Cleanup(func() {
+		*config = previousConfig
+		store = previousStore
+		oidcSigningKey = previousKey
+		PurgeCookieCache()
+	})
+
+	config.OIDC.Enabled = true
+	config.OIDC.Issuer = "https://auth.example.org"
+	config.Cookies.Mode = "database"
+	config.OIDCClients = []OIDCClient{
+		{ID: "public", RedirectURIs: []string{"https://app.example.org/callback"}},
+		{ID: "userinfo", Secret: "secret", RedirectURIs: []string{"https://app.example.org/callback"}},
+		{ID: "strict", Secret: "secret", RedirectURIs: []string{"https://app.example.org/callback"}, RequirePKCE: true},
+	}
+
+	if err = store.SaveUser(User{Username: "alice", DisplayName: "Alice Liddell"}); err != nil {
+		t.Fatalf("SaveUser: %s", err)
+	}
+
+	router := gin.New()
+	router.GET("/oidc/authorize", oidcAuthorize)
+	router.POST("/oidc/token", oidcToken)
+	router.GET("/oidc/userinfo", oidcUserinfo)
+
+	// sign in
+	recorder := httptest.NewRecorder()
+	context, _ := gin.CreateTestContext(recorder)
+	context.Request = httptest.NewRequest(http.MethodGet, "/login", nil)
+	createAndSetAuthCookie(context, "alice", "example.org", "local")
+	authCookie := recorder.Result().Cookies()[0]
+
+	verifier := "verifier-0123456789-0123456789-0123456789"
+	challengeHash := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(challengeHash[:])
+
+	// authorize returns the authorization code, or the error of the redirect
+	authorize := func(clientId string, pkce bool) (string, string) {
+		t.Helper()
+
+		query := url.Values{
+			"client_id":     {clientId},
+			"redirect_uri":  {"https://app.example.org/callback"},
+			"response_type": {"code"},
+			"scope":         {"openid profile"},
+		}
+
+		if pkce {
+			query.Set("code_challenge", challenge)
+			query.Set("code_challenge_method", "S256")
+		}
+
+		request := httptest.NewRequest(http.MethodGet, "/oidc/authorize?"+query.Encode(), nil)
+		request.AddCookie(authCookie)
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		location, err := url.Parse(recorder.Header().Get("Location"))
+
+		if recorder.Code != http.StatusFound || err != nil {
+			t.Fatalf("authorize of client '%s': got status %d and location '%s'", clientId, recorder.Code, recorder.Header().Get("Location"))
+		}
+
+		return location.Query().Get("code"), location.Query().Get("error")
+	}
+
+	if _, errorCode := authorize("public", false); errorCode != "invalid_request" {
+		t.Errorf("authorize of a public client without PKCE: got error '%s', want invalid_request", errorCode)
+	}
+
+	if _, errorCode := authorize("strict", false); errorCode != "invalid_request" {
+		t.Errorf("authorize of a client requiring PKCE without PKCE: got error '%s', want invalid_request", errorCode)
+	}
+
+	if code, _ := authorize("public", true); code == "" {
+		t.Errorf("authorize of a public client with PKCE did not return a code")
+	}
+
+	code, errorCode := authorize("userinfo", false)
+
+	if code == "" {
+		t.Fatalf("authorize of a confidential client without PKCE: got error '%s'", errorCode)
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {"https://app.example.org/callback"},
+		"client_id":     {"userinfo"},
+		"client_secret": {"secret"},
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/oidc/token", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+
+	if recorder.Code != http.StatusOK || json.Unmarshal(recorder.Body.Bytes(), &tokens) != nil {
+		t.Fatalf("token: got status %d and body '%s'", recorder.Code, recorder.Body.String())
+	}
+
+	claims, err := verifyOIDCToken(tokens.IDToken, oidcIDTokenType)
+
+	if err != nil || claims.Name != "Alice Liddell" {
+		t.Errorf("ID token: got %+v, %v, want name 'Alice Liddell'", claims, err)
+	}
+
+	userinfo := func(token string) int {
+		request := httptest.NewRequest(http.MethodGet, "/oidc/userinfo", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		return recorder.Code
+	}
+
+	if status := userinfo(tokens.AccessToken); status != http.StatusOK {
+		t.Errorf("userinfo with the access token: got status %d, want %d", status, http.StatusOK)
+	}
+
+	// the ID token of the client 'userinfo' has the audience 'userinfo'
+	if status := userinfo(tokens.IDToken); status != http.StatusUnauthorized {
+		t.Errorf("userinfo with the ID token: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
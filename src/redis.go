@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// This file implements a minimal client for the Redis serialization protocol (RESP2), which is sufficient for the
+// Redis store (see store_redis.go). Any server that speaks RESP2 can be used, e.g. Redis, Valkey or KeyDB.
+// Refer to https://redis.io/docs/reference/protocol-spec/ for the protocol.
+
+const (
+	// redisMaxIdleConnections is the maximum number of idle connections kept open by a redisClient
+	redisMaxIdleConnections = 8
+
+	// redisReconnectDelay is the time the subscriber waits before it reconnects after a connection error
+	redisReconnectDelay = time.Second
+)
+
+// redisClient is a client for a Redis server with a pool of idle connections.
+type redisClient struct {
+	address  string
+	password string
+	database int
+	timeout  time.Duration
+
+	mutex sync.Mutex
+	idle  []*redisConn
+}
+
+// redisConn is a connection to a Redis server.
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply of the Redis server.
+type redisError string
+
+// Error implements error.
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// newRedisClient returns a client for the Redis server at the given address. The connections are opened on demand.
+func newRedisClient(address string, password string, database int, timeout time.Duration) *redisClient {
+	return &redisClient{
+		address:  address,
+		password: password,
+		database: database,
+		timeout:  timeout,
+	}
+}
+
+// dial opens a new connection to the Redis server, authenticates and selects the configured database.
+func (c *redisClient) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", c.address, c.timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	redisConn := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	var commands [][]string
+
+	if c.password != "" {
+		commands = append(commands, []string{"AUTH", c.password})
+	}
+
+	if c.database != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(c.database)})
+	}
+
+	if len(commands) != 0 {
+		if _, err = redisConn.pipeline(commands, c.timeout); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return redisConn, nil
+}
+
+// Pipeline sends the given commands to the server at once and returns the replies in the same order.
+// Returns the first error reply as error.
+func (c *redisClient) Pipeline(commands ...[]string) ([]interface{}, error) {
+	if conn := c.idleConn(); conn != nil {
+		replies, err := c.pipeline(conn, commands)
+
+		// idle connections may have been closed by the server in the meantime, retry with a new connection
+		if !isRedisConnectionError(err) {
+			return replies, err
+		}
+	}
+
+	conn, err := c.dial()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c.pipeline(conn, commands)
+}
+
+// pipeline sends the given commands using the given connection. The connection is returned to the pool of
+// idle connections unless a connection error occurred.
+func (c *redisClient) pipeline(conn *redisConn, commands [][]string) ([]interface{}, error) {
+	replies, err := conn.pipeline(commands, c.timeout)
+	c.release(conn, err)
+
+	if isRedisConnectionError(err) {
+		return nil, err
+	}
+
+	return replies, err
+}
+
+// idleConn removes an idle connection from the pool and returns it. Returns nil if there is no idle connection.
+func (c *redisClient) idleConn() *redisConn {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.idle) == 0 {
+		return nil
+	}
+
+	conn := c.idle[len(c.idle)-1]
+	c.idle = c.idle[:len(c.idle)-1]
+
+	return conn
+}
+
+// release returns the given connection to the pool of idle connections. The connection is closed instead if err is
+// a connection error, since the connection is in an unknown state after network or protocol errors.
+func (c *redisClient) release(conn *redisConn, err error) {
+	if isRedisConnectionError(err) {
+		_ = conn.conn.Close()
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.idle) < redisMaxIdleConnections {
+		c.idle = append(c.idle, conn)
+	} else {
+		_ = conn.conn.Close()
+	}
+}
+
+// Do sends the given command to the server and returns the reply.
+func (c *redisClient) Do(args ...string) (interface{}, error) {
+	replies, err := c.Pipeline(args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return replies[0], nil
+}
+
+// Transaction executes the given commands atomically (MULTI/EXEC) and returns the replies of the commands.
+func (c *redisClient) Transaction(commands ...[]string) ([]interface{}, error) {
+	commands = append(append([][]string{{"MULTI"}}, commands...), []string{"EXEC"})
+
+	replies, err := c.Pipeline(commands...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, ok := replies[len(replies)-1].([]interface{})
+
+	if !ok {
+		return nil, errors.New("redis: transaction was aborted")
+	}
+
+	for _, result := range results {
+		if err, ok := result.(redisError); ok {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// Scan returns all keys matching the given pattern. The keys are collected with SCAN, so the server is not blocked.
+func (c *redisClient) Scan(pattern string) ([]string, error) {
+	var keys []string
+
+	cursor := "0"
+
+	for {
+		reply, err := c.Do("SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+
+		if err != nil {
+			return nil, err
+		}
+
+		values, ok := reply.([]interface{})
+
+		if !ok || len(values) != 2 {
+			return nil, errors.New("redis: unexpected reply to SCAN")
+		}
+
+		cursor = redisString(values[0])
+
+		keys = append(keys, redisStrings(values[1])...)
+
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+// Subscribe calls handler for every message published on the given channel until stop is closed.
+// Lost connections are reestablished, messages published in the meantime are lost. subscribed is called after
+// every successful (re)subscription, so that the caller can discard state that depends on lost messages.
+func (c *redisClient) Subscribe(channel string, subscribed func(), handler func(message string), stop <-chan struct{}) {
+	for {
+		conn, err := c.dial()
+
+		if err == nil {
+			done := make(chan struct{})
+
+			// close the connection to interrupt the blocking read once stop is closed
+			go func() {
+				select {
+				case <-stop:
+					_ = conn.conn.Close()
+				case <-done:
+				}
+			}()
+
+			err = conn.receive(channel, subscribed, handler, c.timeout)
+			close(done)
+			_ = conn.conn.Close()
+		}
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		appLog.Printf("error: lost subscription to redis channel '%s', reconnecting. %s\n", channel, err)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(redisReconnectDelay):
+		}
+	}
+}
+
+// Close closes all idle connections.
+func (c *redisClient) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, conn := range c.idle {
+		_ = conn.conn.Close()
+	}
+
+	c.idle = nil
+
+	return nil
+}
+
+// pipeline writes the given commands and reads one reply per command.
+func (c *redisConn) pipeline(commands [][]string, timeout time.Duration) ([]interface{}, error) {
+	if timeout > 0 {
+		_ = c.conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	writer := bufio.NewWriter(c.conn)
+
+	for _, command := range commands {
+		writeRedisCommand(writer, command)
+	}
+
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(commands))
+
+	var firstErr error
+
+	for i := range commands {
+		reply, err := readRedisReply(c.reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if replyErr, ok := reply.(redisError); ok && firstErr == nil {
+			firstErr = replyErr
+		}
+
+		replies[i] = reply
+	}
+
+	return replies, firstErr
+}
+
+// receive subscribes to the given channel, calls subscribed once the subscription is confirmed and calls handler for
+// every message until the connection fails.
+func (c *redisConn) receive(channel string, subscribed func(), handler func(message string), timeout time.Duration) error {
+	if _, err := c.pipeline([][]string{{"SUBSCRIBE", channel}}, timeout); err != nil {
+		return err
+	}
+
+	subscribed()
+
+	// messages may arrive at any time
+	_ = c.conn.SetDeadline(time.Time{})
+
+	for {
+		reply, err := readRedisReply(c.reader)
+
+		if err != nil {
+			return err
+		}
+
+		values, ok := reply.([]interface{})
+
+		if ok && len(values) == 3 && redisString(values[0]) == "message" {
+			handler(redisString(values[2]))
+		}
+	}
+}
+
+// writeRedisCommand writes the given command as RESP array of bulk strings.
+func writeRedisCommand(writer *bufio.Writer, args []string) {
+	_, _ = fmt.Fprintf(writer, "*%d\r\n", len(args))
+
+	for _, arg := range args {
+		_, _ = fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readRedisReply reads a single reply. Simple strings are returned as string, bulk strings as []byte, integers as
+// int64, arrays as []interface{} and error replies as redisError. Null replies are returned as nil.
+func readRedisReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply '%s'", line)
+	}
+
+	payload := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return redisError(payload), nil
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		length, err := strconv.Atoi(payload)
+
+		if err != nil || length < 0 {
+			return nil, err
+		}
+
+		buffer := make([]byte, length+2)
+
+		if _, err = io.ReadFull(reader, buffer); err != nil {
+			return nil, err
+		}
+
+		return buffer[:length], nil
+	case '*':
+		length, err := strconv.Atoi(payload)
+
+		if err != nil || length < 0 {
+			return nil, err
+		}
+
+		values := make([]interface{}, length)
+
+		for i := range values {
+			if values[i], err = readRedisReply(reader); err != nil {
+				return nil, err
+			}
+		}
+
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("redis: invalid reply '%s'", line)
+}
+
+// isRedisConnectionError returns true if the given error is not nil and not an error reply of the server.
+func isRedisConnectionError(err error) bool {
+	var replyErr redisError
+
+	return err != nil && !errors.As(err, &replyErr)
+}
+
+// redisString converts a string reply to a string. Returns an empty string for other replies.
+func redisString(reply interface{}) string {
+	switch value := reply.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	}
+
+	return ""
+}
+
+// redisStrings converts an array reply to a slice of strings.
+func redisStrings(reply interface{}) []string {
+	values, _ := reply.([]interface{})
+	strings := make([]string, 0, len(values))
+
+	for _, value := range values {
+		strings = append(strings, redisString(value))
+	}
+
+	return strings
+}
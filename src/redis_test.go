@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedisServer is an in-process stand-in for a Redis server. It speaks RESP2 and implements the commands used by
+// the Redis store (strings, sets, hashes, expiry, SCAN, MULTI/EXEC with WATCH and pub/sub) with the semantics of
+// Redis.
+type fakeRedisServer struct {
+	listener net.Listener
+	password string
+
+	mutex   sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]bool
+	hashes  map[string]map[string]string
+	expires map[string]time.Time
+	// versions counts the modifications of every key, EXEC fails if a watched key was modified
+	versions    map[string]int
+	subscribers map[string][]chan string
+	// subscriberConns contains the connections that subscribed to a channel
+	subscriberConns map[net.Conn]bool
+	// subscribed receives the channel name whenever a connection subscribed to a channel
+	subscribed chan string
+}
+
+// newFakeRedisServer starts a fakeRedisServer on a random local port. The server is stopped once the test ends.
+func newFakeRedisServer(t *testing.T, password string) *fakeRedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("could not start fake redis server: %s", err)
+	}
+
+	server := &fakeRedisServer{
+		listener:        listener,
+		password:        password,
+		strings:         make(map[string]string),
+		sets:            make(map[string]map[string]bool),
+		hashes:          make(map[string]map[string]string),
+		expires:         make(map[string]time.Time),
+		versions:        make(map[string]int),
+		subscribers:     make(map[string][]chan string),
+		subscriberConns: make(map[net.Conn]bool),
+		subscribed:      make(chan string, 16),
+	}
+
+	go server.serve()
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	return server
+}
+
+// Address returns the address the server listens on.
+func (s *fakeRedisServer) Address() string {
+	return s.listener.Addr().String()
+}
+
+// serve accepts connections until the listener is closed.
+func (s *fakeRedisServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+// handle reads and executes the commands of a single connection.
+func (s *fakeRedisServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	authenticated := s.password == ""
+
+	var queue [][]string
+	inTransaction := false
+	watched := make(map[string]int)
+
+	for {
+		reply, err := readRedisReply(reader)
+
+		if err != nil {
+			return
+		}
+
+		args := redisStrings(reply)
+
+		if len(args) == 0 {
+			return
+		}
+
+		command := strings.ToUpper(args[0])
+
+		var result interface{}
+
+		switch {
+		case command == "AUTH":
+			authenticated = len(args) == 2 && args[1] == s.password
+
+			if authenticated {
+				result = "OK"
+			} else {
+				result = redisError("WRONGPASS invalid password")
+			}
+		case !authenticated:
+			result = redisError("NOAUTH Authentication required.")
+		case command == "SUBSCRIBE" && len(args) == 2:
+			s.subscribe(conn, writer, args[1])
+			return
+		case command == "WATCH" && !inTransaction:
+			s.mutex.Lock()
+
+			for _, key := range args[1:] {
+				watched[key] = s.versions[key]
+			}
+
+			s.mutex.Unlock()
+
+			result = "OK"
+		case command == "UNWATCH":
+			watched = make(map[string]int)
+			result = "OK"
+		case command == "MULTI":
+			inTransaction = true
+			queue = nil
+			result = "OK"
+		case command == "EXEC":
+			results := make([]interface{}, len(queue))
+
+			s.mutex.Lock()
+			s.expire()
+
+			for key, version := range watched {
+				if s.versions[key] != version {
+					results = nil
+				}
+			}
+
+			for i := 0; results != nil && i < len(queue); i++ {
+				results[i] = s.execute(queue[i])
+			}
+
+			s.mutex.Unlock()
+
+			inTransaction = false
+			watched = make(map[string]int)
+
+			if results != nil {
+				result = results
+			}
+		case inTransaction:
+			queue = append(queue, args)
+			result = "QUEUED"
+		default:
+			s.mutex.Lock()
+			result = s.execute(args)
+			s.mutex.Unlock()
+		}
+
+		writeFakeRedisReply(writer, result)
+
+		// flush once all pipelined commands were answered
+		if reader.Buffered() == 0 {
+			if err = writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// subscribe forwards the messages published on the given channel to the connection until it is closed.
+func (s *fakeRedisServer) subscribe(conn net.Conn, writer *bufio.Writer, channel string) {
+	messages := make(chan string, 16)
+
+	s.mutex.Lock()
+	s.subscribers[channel] = append(s.subscribers[channel], messages)
+	s.subscriberConns[conn] = true
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		delete(s.subscriberConns, conn)
+
+		subscribers := s.subscribers[channel]
+
+		for i := range subscribers {
+			if subscribers[i] == messages {
+				s.subscribers[channel] = append(subscribers[:i], subscribers[i+1:]...)
+				break
+			}
+		}
+	}()
+
+	writeFakeRedisReply(writer, []interface{}{[]byte("subscribe"), []byte(channel), int64(1)})
+
+	if writer.Flush() != nil {
+		return
+	}
+
+	s.subscribed <- channel
+
+	// detect closed connections
+	closed := make(chan struct{})
+
+	go func() {
+		_, _ = conn.Read(make([]byte, 1))
+		close(closed)
+	}()
+
+	for {
+		select {
+		case message := <-messages:
+			writeFakeRedisReply(writer, []interface{}{[]byte("message"), []byte(channel), []byte(message)})
+
+			if writer.Flush() != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// dropSubscriptions closes all connections that subscribed to a channel, e.g. to simulate a restart of the server.
+func (s *fakeRedisServer) dropSubscriptions() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for conn := range s.subscriberConns {
+		_ = conn.Close()
+	}
+}
+
+// execute executes a single command. The caller must hold the mutex.
+func (s *fakeRedisServer) execute(args []string) interface{} {
+	command := strings.ToUpper(args[0])
+	s.expire()
+
+	switch command {
+	case "SELECT":
+		return "OK"
+	case "GET":
+		if value, ok := s.strings[args[1]]; ok {
+			return []byte(value)
+		}
+
+		return nil
+	case "SET":
+		keepTTL, onlyIfExists := false, false
+
+		for _, option := range args[3:] {
+			switch strings.ToUpper(option) {
+			case "KEEPTTL":
+				keepTTL = true
+			case "XX":
+				onlyIfExists = true
+			default:
+				return redisError("ERR syntax error")
+			}
+		}
+
+		if onlyIfExists && !s.exists(args[1]) {
+			return nil
+		}
+
+		expires, hasExpiry := s.expires[args[1]]
+		s.deleteKey(args[1])
+		s.strings[args[1]] = args[2]
+
+		if keepTTL && hasExpiry {
+			s.expires[args[1]] = expires
+		}
+
+		return "OK"
+	case "DEL":
+		var count int64
+
+		for _, key := range args[1:] {
+			if s.exists(key) {
+				count++
+			}
+
+			s.deleteKey(key)
+		}
+
+		return count
+	case "PEXPIREAT":
+		milliseconds, err := strconv.ParseInt(args[2], 10, 64)
+
+		if err != nil {
+			return redisError("ERR value is not an integer or out of range")
+		}
+
+		if !s.exists(args[1]) {
+			return int64(0)
+		}
+
+		s.expires[args[1]] = time.UnixMilli(milliseconds)
+		s.versions[args[1]]++
+		s.expire()
+
+		return int64(1)
+	case "SADD", "SREM":
+		set, ok := s.sets[args[1]]
+
+		if !ok {
+			set = make(map[string]bool)
+			s.sets[args[1]] = set
+		}
+
+		var count int64
+
+		for _, member := range args[2:] {
+			if set[member] != (command == "SADD") {
+				count++
+			}
+
+			if command == "SADD" {
+				set[member] = true
+			} else {
+				delete(set, member)
+			}
+		}
+
+		if len(set) == 0 {
+			delete(s.sets, args[1])
+		}
+
+		s.versions[args[1]]++
+
+		return count
+	case "SMEMBERS":
+		var members []interface{}
+
+		for member := range s.sets[args[1]] {
+			members = append(members, []byte(member))
+		}
+
+		return members
+	case "EXISTS":
+		if s.exists(args[1]) {
+			return int64(1)
+		}
+
+		return int64(0)
+	case "HSET", "HDEL":
+		hash, ok := s.hashes[args[1]]
+
+		if !ok {
+			hash = make(map[string]string)
+			s.hashes[args[1]] = hash
+		}
+
+		var count int64
+
+		if command == "HSET" {
+			for i := 2; i+1 < len(args); i += 2 {
+				if _, ok := hash[args[i]]; !ok {
+					count++
+				}
+
+				hash[args[i]] = args[i+1]
+			}
+		} else {
+			for _, field := range args[2:] {
+				if _, ok := hash[field]; ok {
+					count++
+				}
+
+				delete(hash, field)
+			}
+		}
+
+		if len(hash) == 0 {
+			delete(s.hashes, args[1])
+		}
+
+		s.versions[args[1]]++
+
+		return count
+	case "HGET":
+		if value, ok := s.hashes[args[1]][args[2]]; ok {
+			return []byte(value)
+		}
+
+		return nil
+	case "HGETALL":
+		var fields []string
+
+		for field := range s.hashes[args[1]] {
+			fields = append(fields, field)
+		}
+
+		sort.Strings(fields)
+
+		values := []interface{}{}
+
+		for _, field := range fields {
+			values = append(values, []byte(field), []byte(s.hashes[args[1]][field]))
+		}
+
+		return values
+	case "SCAN":
+		// all keys are returned at once, MATCH supports prefix patterns ('prefix*') only
+		pattern := "*"
+
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.EqualFold(args[i], "MATCH") {
+				pattern = args[i+1]
+			}
+		}
+
+		var keys []string
+
+		for _, key := range s.keys() {
+			if strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+				keys = append(keys, key)
+			}
+		}
+
+		sort.Strings(keys)
+
+		values := make([]interface{}, len(keys))
+
+		for i := range keys {
+			values[i] = []byte(keys[i])
+		}
+
+		return []interface{}{[]byte("0"), values}
+	case "PUBLISH":
+		subscribers := s.subscribers[args[1]]
+
+		for _, subscriber := range subscribers {
+			subscriber <- args[2]
+		}
+
+		return int64(len(subscribers))
+	}
+
+	return redisError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+}
+
+// exists returns true if the given key exists. The caller must hold the mutex.
+func (s *fakeRedisServer) exists(key string) bool {
+	_, isString := s.strings[key]
+	_, isSet := s.sets[key]
+	_, isHash := s.hashes[key]
+
+	return isString || isSet || isHash
+}
+
+// keys returns all keys. The caller must hold the mutex.
+func (s *fakeRedisServer) keys() []string {
+	var keys []string
+
+	for key := range s.strings {
+		keys = append(keys, key)
+	}
+
+	for key := range s.sets {
+		keys = append(keys, key)
+	}
+
+	for key := range s.hashes {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// deleteKey deletes the given key. The caller must hold the mutex.
+func (s *fakeRedisServer) deleteKey(key string) {
+	delete(s.strings, key)
+	delete(s.sets, key)
+	delete(s.hashes, key)
+	delete(s.expires, key)
+	s.versions[key]++
+}
+
+// expire deletes all expired keys. The caller must hold the mutex.
+func (s *fakeRedisServer) expire() {
+	now := time.Now()
+
+	for key, expires := range s.expires {
+		if !expires.After(now) {
+			s.deleteKey(key)
+		}
+	}
+}
+
+// writeFakeRedisReply writes the given reply in the format returned by readRedisReply.
+func writeFakeRedisReply(writer *bufio.Writer, reply interface{}) {
+	switch value := reply.(type) {
+	case nil:
+		_, _ = writer.WriteString("$-1\r\n")
+	case string:
+		_, _ = fmt.Fprintf(writer, "+%s\r\n", value)
+	case redisError:
+		_, _ = fmt.Fprintf(writer, "-%s\r\n", string(value))
+	case int64:
+		_, _ = fmt.Fprintf(writer, ":%d\r\n", value)
+	case []byte:
+		_, _ = fmt.Fprintf(writer, "$%d\r\n%s\r\n", len(value), value)
+	case []interface{}:
+		_, _ = fmt.Fprintf(writer, "*%d\r\n", len(value))
+
+		for _, element := range value {
+			writeFakeRedisReply(writer, element)
+		}
+	}
+}
+
+func TestRedisClient(t *testing.T) {
+	server := newFakeRedisServer(t, "secret")
+
+	client := newRedisClient(server.Address(), "secret", 1, time.Second)
+	defer client.Close()
+
+	if _, err := client.Do("SET", "key", "value"); err != nil {
+		t.Fatalf("SET: %s", err)
+	}
+
+	if reply, err := client.Do("GET", "key"); err != nil || redisString(reply) != "value" {
+		t.Fatalf("GET: got %v, %v, want 'value'", reply, err)
+	}
+
+	if reply, err := client.Do("GET", "missing"); err != nil || reply != nil {
+		t.Fatalf("GET of a missing key: got %v, %v, want nil", reply, err)
+	}
+
+	results, err := client.Transaction(
+		[]string{"SADD", "set", "a", "b"},
+		[]string{"SREM", "set", "a"},
+	)
+
+	if err != nil || len(results) != 2 || results[0] != int64(2) || results[1] != int64(1) {
+		t.Fatalf("Transaction: got %v, %v", results, err)
+	}
+
+	if _, err = client.Transaction([]string{"UNKNOWN"}); err == nil {
+		t.Errorf("Transaction: error replies of commands are not returned")
+	}
+
+	keys, err := client.Scan("k*")
+
+	if err != nil || len(keys) != 1 || keys[0] != "key" {
+		t.Errorf("Scan: got %v, %v, want [key]", keys, err)
+	}
+
+	if _, err = newRedisClient(server.Address(), "wrong", 0, time.Second).Do("GET", "key"); err == nil {
+		t.Errorf("Do with a wrong password: no error")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// This file handles the authorization rules. Rules are defined in the config.ini using sections prefixed
+// with 'Rule.' (e.g. [Rule.admin]) and are evaluated in the order of their definition. The first rule
+// whose host and path patterns match the original request decides if an authenticated user is granted access.
+
+// Rule :: [Rule.<name>]-Section of .ini
+type Rule struct {
+	Name        string   `ini:"-"`
+	Host        string   `ini:"host"`
+	Path        string   `ini:"path"`
+	AllowUsers  []string `ini:"allow_users"`
+	AllowGroups []string `ini:"allow_groups"`
+	DenyUsers   []string `ini:"deny_users"`
+	DenyGroups  []string `ini:"deny_groups"`
+
+	hostRegex *regexp.Regexp
+	pathRegex *regexp.Regexp
+}
+
+// AuthorizationDecision describes the result of evaluating the authorization rules for a request.
+type AuthorizationDecision struct {
+	Allowed bool
+	Rule    *Rule // Rule :: matched rule, nil if the default policy was applied
+	Reason  string
+}
+
+// compile compiles the host and path patterns of the rule.
+// Returns an error if any pattern could not be compiled.
+func (rule *Rule) compile() error {
+	var err error
+
+	rule.hostRegex, err = compilePattern(rule.Host, true)
+
+	if err != nil {
+		return fmt.Errorf("invalid host pattern '%s': %s", rule.Host, err)
+	}
+
+	rule.pathRegex, err = compilePattern(rule.Path, false)
+
+	if err != nil {
+		return fmt.Errorf("invalid path pattern '%s': %s", rule.Path, err)
+	}
+
+	return nil
+}
+
+// Matches returns true if the host and path patterns of the rule match the given host and path.
+// An empty pattern matches anything.
+func (rule *Rule) Matches(host string, path string) bool {
+	if rule.hostRegex != nil && !rule.hostRegex.MatchString(host) {
+		return false
+	}
+
+	if rule.pathRegex != nil && !rule.pathRegex.MatchString(path) {
+		return false
+	}
+
+	return true
+}
+
+// Evaluate decides if the user with the given username and groups is granted access by this rule.
+// Deny lists take precedence over allow lists. If both allow lists are empty, any authenticated user is allowed.
+func (rule *Rule) Evaluate(username string, groups []string) AuthorizationDecision {
+	if containsFold(rule.DenyUsers, username) {
+		return AuthorizationDecision{Allowed: false, Rule: rule, Reason: fmt.Sprintf("user '%s' is denied by rule '%s'", username, rule.Name)}
+	}
+
+	for _, group := range groups {
+		if containsFold(rule.DenyGroups, group) {
+			return AuthorizationDecision{Allowed: false, Rule: rule, Reason: fmt.Sprintf("group '%s' is denied by rule '%s'", group, rule.Name)}
+		}
+	}
+
+	if len(rule.AllowUsers) == 0 && len(rule.AllowGroups) == 0 {
+		return AuthorizationDecision{Allowed: true, Rule: rule, Reason: fmt.Sprintf("rule '%s' allows any authenticated user", rule.Name)}
+	}
+
+	if containsFold(rule.AllowUsers, username) {
+		return AuthorizationDecision{Allowed: true, Rule: rule, Reason: fmt.Sprintf("user '%s' is allowed by rule '%s'", username, rule.Name)}
+	}
+
+	for _, group := range groups {
+		if containsFold(rule.AllowGroups, group) {
+			return AuthorizationDecision{Allowed: true, Rule: rule, Reason: fmt.Sprintf("group '%s' is allowed by rule '%s'", group, rule.Name)}
+		}
+	}
+
+	return AuthorizationDecision{Allowed: false, Rule: rule, Reason: fmt.Sprintf("neither user '%s' nor any of its groups are allowed by rule '%s'", username, rule.Name)}
+}
+
+// EvaluateRules evaluates the configured rules for the given user and request host/path.
+// The first matching rule decides. If no rule matches, the configured default policy is applied.
+func EvaluateRules(username string, groups []string, host string, path string) AuthorizationDecision {
+	rules := GetRules()
+
+	for i := range rules {
+		rule := &rules[i]
+
+		if rule.Matches(host, path) {
+			return rule.Evaluate(username, groups)
+		}
+	}
+
+	if strings.EqualFold(GetAuthorizationDefaultPolicy(), "deny") {
+		return AuthorizationDecision{Allowed: false, Reason: "no rule matched, default policy is 'deny'"}
+	}
+
+	return AuthorizationDecision{Allowed: true, Reason: "no rule matched, default policy is 'allow'"}
+}
+
+// compilePattern converts the given wildcard pattern to a regular expression. The wildcard '*' matches any
+// sequence of characters (including '/'). Returns nil if the pattern is empty.
+func compilePattern(pattern string, caseInsensitive bool) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	expression := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+
+	if caseInsensitive {
+		expression = "(?i)" + expression
+	}
+
+	return regexp.Compile(expression)
+}
+
+// containsFold returns true if the given list contains the given value (case-insensitive).
+func containsFold(list []string, value string) bool {
+	for _, element := range list {
+		if strings.EqualFold(strings.TrimSpace(element), value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"testing"
+)
+
+// newTestRules compiles the given rules and configures them as the authorization rules of the test.
+func newTestRules(t *testing.T, defaultPolicy string, rules ...Rule) {
+	t.Helper()
+
+	previousConfig := *config
+
+	t.Cleanup(func() {
+		*config = previousConfig
+	})
+
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			t.Fatalf("compile: %s", err)
+		}
+	}
+
+	config.Rules = rules
+	config.Authorization.DefaultPolicy = defaultPolicy
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		path    string
+		reqHost string
+		reqPath string
+		matches bool
+	}{
+		{name: "empty patterns", reqHost: "example.com", reqPath: "/", matches: true},
+		{name: "exact host", host: "app.example.com", reqHost: "app.example.com", reqPath: "/", matches: true},
+		{name: "host is case-insensitive", host: "app.example.com", reqHost: "APP.Example.com", reqPath: "/", matches: true},
+		{name: "other host", host: "app.example.com", reqHost: "example.com", reqPath: "/", matches: false},
+		{name: "host suffix", host: "app.example.com", reqHost: "app.example.com.evil.com", reqPath: "/", matches: false},
+		{name: "wildcard host", host: "*.example.com", reqHost: "app.example.com", reqPath: "/", matches: true},
+		{name: "wildcard host without subdomain", host: "*.example.com", reqHost: "example.com", reqPath: "/", matches: false},
+		{name: "wildcard host of other domain", host: "*.example.com", reqHost: "app.example.org", reqPath: "/", matches: false},
+		{name: "dot is not a wildcard", host: "app.example.com", reqHost: "app-example.com", reqPath: "/", matches: false},
+		{name: "exact path", path: "/admin", reqHost: "example.com", reqPath: "/admin", matches: true},
+		{name: "exact path with suffix", path: "/admin", reqHost: "example.com", reqPath: "/admin/users", matches: false},
+		{name: "wildcard path", path: "/admin/*", reqHost: "example.com", reqPath: "/admin/users/1", matches: true},
+		{name: "wildcard path of parent", path: "/admin/*", reqHost: "example.com", reqPath: "/admin", matches: false},
+		{name: "path is case-sensitive", path: "/admin/*", reqHost: "example.com", reqPath: "/Admin/users", matches: false},
+		{name: "wildcard in the middle", path: "/api/*/admin", reqHost: "example.com", reqPath: "/api/v1/admin", matches: true},
+		{name: "host and path", host: "*.example.com", path: "/admin*", reqHost: "app.example.com", reqPath: "/admin", matches: true},
+		{name: "host and other path", host: "*.example.com", path: "/admin*", reqHost: "app.example.com", reqPath: "/public", matches: false},
+	}
+
+	for _, test := range tests {
+		rule := Rule{Name: test.name, Host: test.host, Path: test.path}
+
+		if err := rule.compile(); err != nil {
+			t.Fatalf("%s: compile: %s", test.name, err)
+		}
+
+		if matches := rule.Matches(test.reqHost, test.reqPath); matches != test.matches {
+			t.Errorf("%s: Matches('%s', '%s') of host '%s' and path '%s' = %v, want %v",
+				test.name, test.reqHost, test.reqPath, test.host, test.path, matches, test.matches)
+		}
+	}
+}
+
+func TestRuleEvaluate(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     Rule
+		username string
+		groups   []string
+		allowed  bool
+	}{
+		{name: "no allow lists", rule: Rule{}, username: "alice", allowed: true},
+		{name: "allowed user", rule: Rule{AllowUsers: []string{"alice"}}, username: "alice", allowed: true},
+		{name: "allowed user is case-insensitive", rule: Rule{AllowUsers: []string{" Alice "}}, username: "alice", allowed: true},
+		{name: "other user", rule: Rule{AllowUsers: []string{"bob"}}, username: "alice", allowed: false},
+		{name: "allowed group", rule: Rule{AllowGroups: []string{"admins"}}, username: "alice", groups: []string{"users", "admins"}, allowed: true},
+		{name: "other group", rule: Rule{AllowGroups: []string{"admins"}}, username: "alice", groups: []string{"users"}, allowed: false},
+		{name: "denied user", rule: Rule{DenyUsers: []string{"alice"}}, username: "alice", allowed: false},
+		{name: "denied group", rule: Rule{DenyGroups: []string{"guests"}}, username: "alice", groups: []string{"guests"}, allowed: false},
+		{
+			name:     "denied user beats allowed user",
+			rule:     Rule{AllowUsers: []string{"alice"}, DenyUsers: []string{"alice"}},
+			username: "alice",
+			allowed:  false,
+		},
+		{
+			name:     "denied group beats allowed user",
+			rule:     Rule{AllowUsers: []string{"alice"}, DenyGroups: []string{"guests"}},
+			username: "alice",
+			groups:   []string{"guests"},
+			allowed:  false,
+		},
+		{
+			name:     "denied group beats allowed group",
+			rule:     Rule{AllowGroups: []string{"admins"}, DenyGroups: []string{"guests"}},
+			username: "alice",
+			groups:   []string{"admins", "guests"},
+			allowed:  false,
+		},
+	}
+
+	for _, test := range tests {
+		if decision := test.rule.Evaluate(test.username, test.groups); decision.Allowed != test.allowed {
+			t.Errorf("%s: got %v (%s), want %v", test.name, decision.Allowed, decision.Reason, test.allowed)
+		}
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	newTestRules(t, "deny",
+		Rule{Name: "public", Host: "app.example.com", Path: "/public/*"},
+		Rule{Name: "admin", Host: "app.example.com", Path: "/admin/*", AllowGroups: []string{"admins"}},
+		Rule{Name: "banned", Host: "app.example.com", DenyUsers: []string{"mallory"}},
+		Rule{Name: "app", Host: "*.example.com", AllowGroups: []string{"users"}},
+	)
+
+	tests := []struct {
+		name     string
+		username string
+		groups   []string
+		host     string
+		path     string
+		rule     string
+		allowed  bool
+	}{
+		{name: "first match wins over later deny", username: "mallory", host: "app.example.com", path: "/public/index.html", rule: "public", allowed: true},
+		{name: "later rule after non-matching path", username: "mallory", host: "app.example.com", path: "/index.html", rule: "banned", allowed: false},
+		{name: "first match denies", username: "alice", groups: []string{"users"}, host: "app.example.com", path: "/admin/index.html", rule: "admin", allowed: false},
+		{name: "first match allows", username: "alice", groups: []string{"admins"}, host: "app.example.com", path: "/admin/index.html", rule: "admin", allowed: true},
+		{name: "wildcard host", username: "bob", groups: []string{"users"}, host: "wiki.example.com", path: "/", rule: "app", allowed: true},
+		{name: "default policy", username: "bob", groups: []string{"users"}, host: "example.org", path: "/", rule: "", allowed: false},
+	}
+
+	for _, test := range tests {
+		decision := EvaluateRules(test.username, test.groups, test.host, test.path)
+		rule := ""
+
+		if decision.Rule != nil {
+			rule = decision.Rule.Name
+		}
+
+		if decision.Allowed != test.allowed || rule != test.rule {
+			t.Errorf("%s: got %v by rule '%s' (%s), want %v by rule '%s'", test.name, decision.Allowed, rule, decision.Reason, test.allowed, test.rule)
+		}
+	}
+
+	config.Authorization.DefaultPolicy = "allow"
+
+	if decision := EvaluateRules("bob", nil, "example.org", "/"); !decision.Allowed || decision.Rule != nil {
+		t.Errorf("default policy 'allow': got %v (%s)", decision.Allowed, decision.Reason)
+	}
+}
+
+func TestCleanRequestPath(t *testing.T) {
+	tests := map[string]string{
+		"":                  "/",
+		"/":                 "/",
+		"public":            "/public",
+		"/public/../admin":  "/admin",
+		"/public/./index":   "/public/index",
+		"//admin//users/":   "/admin/users/",
+		"/../../etc/passwd": "/etc/passwd",
+		"/admin/.":          "/admin",
+	}
+
+	for requestPath, want := range tests {
+		if got := CleanRequestPath(requestPath); got != want {
+			t.Errorf("CleanRequestPath('%s') = '%s', want '%s'", requestPath, got, want)
+		}
+	}
+}
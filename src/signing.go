@@ -0,0 +1,655 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file handles the stateless signed session mode ('[Cookies] mode = signed'). Signed session tokens carry
+// the username, the groups, the expiry and a session ID and are verified using HMAC-SHA256 without a database
+// lookup. Signing keys and a small revocation list are stored in the database and kept in memory, so logouts,
+// 'cookie purge' and 'user remove' still invalidate signed sessions.
+// Example for a signed session token: 'v1.eyJzaWQiOi......In0.kZ3......Q8'.
+
+const (
+	signedSessionPrefix = "v1."
+
+	// signingStateRefreshInterval defines how often the signing keys and the revocation list are reloaded
+	// from the database, so changes made by the CLI take effect in a running server
+	signingStateRefreshInterval = 10 * time.Second
+)
+
+// SigningKey is the structure for the database representation of a session signing key
+type SigningKey struct {
+	ID         string    `json:"id"`
+	Secret     []byte    `json:"secret"`
+	Created    time.Time `json:"created"`
+	Retired    time.Time `json:"retired"`    // Retired :: zero value for the current signing key
+	GraceUntil time.Time `json:"graceUntil"` // GraceUntil :: tokens signed with a retired key are accepted until then
+}
+
+// SignedSessionClaims is the payload of a signed session token.
+type SignedSessionClaims struct {
+	SessionID string   `json:"sid"`
+	Username  string   `json:"sub"`
+	Domain    string   `json:"dom"`
+	Groups    []string `json:"grp,omitempty"`
+	Email     string   `json:"eml,omitempty"`
+	Created   int64    `json:"cat"`
+	IssuedAt  int64    `json:"iat"` // IssuedAt :: unix time in milliseconds
+	Expires   int64    `json:"exp"`
+	KeyID     string   `json:"kid"`
+}
+
+// signingState is the in-memory copy of the signing keys and the revocation list.
+type signingState struct {
+	mutex  sync.RWMutex
+	loaded time.Time
+	// forcedReload is the time of the last reload that was forced by an unknown signing key ID
+	forcedReload time.Time
+
+	keys []SigningKey
+
+	// revokedSessions maps revoked session IDs to the expiry of the session
+	revokedSessions map[string]time.Time
+	// revokedBefore maps usernames to the time before which all sessions of the user are revoked.
+	// The empty username revokes the sessions of all users.
+	revokedBefore map[string]time.Time
+}
+
+var signing = &signingState{
+	revokedSessions: make(map[string]time.Time),
+	revokedBefore:   make(map[string]time.Time),
+}
+
+// IsSignedSessionToken returns true if the given token is a signed session token.
+func IsSignedSessionToken(token string) bool {
+	return strings.HasPrefix(token, signedSessionPrefix)
+}
+
+// SignSession creates and returns a signed session token for the given cookie using the current signing key.
+func SignSession(cookie *Cookie) (string, error) {
+	key, err := getCurrentSigningKey()
+
+	if err != nil {
+		return "", err
+	}
+
+	claims := SignedSessionClaims{
+		SessionID: cookie.ID,
+		Username:  cookie.Username,
+		Domain:    cookie.Domain,
+		Groups:    cookie.Groups,
+		Email:     cookie.Email,
+		Created:   cookie.Created.Unix(),
+		IssuedAt:  time.Now().UnixMilli(),
+		Expires:   cookie.Expires.Unix(),
+		KeyID:     key.ID,
+	}
+
+	payload, err := json.Marshal(claims)
+
+	if err != nil {
+		return "", err
+	}
+
+	unsignedToken := signedSessionPrefix + base64.RawURLEncoding.EncodeToString(payload)
+
+	cookie.signingKeyID = key.ID
+
+	return unsignedToken + "." + base64.RawURLEncoding.EncodeToString(computeSignature(key.Secret, unsignedToken)), nil
+}
+
+// VerifySignedSession verifies the signature, the expiry and the revocation status of the given signed session
+// token and returns the corresponding Cookie. The issue time of the token is the last activity of the Cookie.
+// Returns nil and an error if the token is invalid.
+func VerifySignedSession(token string) (*Cookie, error) {
+	separator := strings.LastIndex(token, ".")
+
+	if !IsSignedSessionToken(token) || separator <= len(signedSessionPrefix) {
+		return nil, errors.New("signed session token does not match syntax")
+	}
+
+	unsignedToken := token[:separator]
+
+	signature, err := base64.RawURLEncoding.DecodeString(token[separator+1:])
+
+	if err != nil {
+		return nil, errors.New("signed session token does not match syntax")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(unsignedToken, signedSessionPrefix))
+
+	if err != nil {
+		return nil, errors.New("signed session token does not match syntax")
+	}
+
+	var claims SignedSessionClaims
+
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("signed session token does not match syntax")
+	}
+
+	key := getSigningKey(claims.KeyID)
+
+	if key == nil {
+		return nil, errors.New("error: signing key not found or grace period expired")
+	}
+
+	if !hmac.Equal(signature, computeSignature(key.Secret, unsignedToken)) {
+		return nil, errors.New("error: invalid signature")
+	}
+
+	if time.Unix(claims.Expires, 0).Before(time.Now()) {
+		return nil, errors.New("error: signed session is expired")
+	}
+
+	if isSignedSessionRevoked(&claims) {
+		return nil, errors.New("error: signed session was revoked")
+	}
+
+	return &Cookie{
+		ID:           claims.SessionID,
+		Name:         "Nginx-Auth-Server-Token",
+		Expires:      time.Unix(claims.Expires, 0),
+		Created:      time.Unix(claims.Created, 0),
+		LastSeen:     time.UnixMilli(claims.IssuedAt),
+		Domain:       claims.Domain,
+		Username:     claims.Username,
+		Groups:       claims.Groups,
+		Email:        claims.Email,
+		HttpOnly:     true,
+		Secure:       GetCookieSecure(),
+		Signed:       true,
+		signingKeyID: claims.KeyID,
+	}, nil
+}
+
+// NeedsResigning returns true if the given signed session cookie was signed with a retired signing key, or if an
+// idle timeout is configured and the token is older than the write interval of the last activity. The issue time of
+// the token is the last activity of signed sessions (see VerifySignedSession), so actively used sessions are re-signed
+// before they exceed the idle timeout.
+func NeedsResigning(cookie *Cookie) bool {
+	if !cookie.Signed {
+		return false
+	}
+
+	if GetCookieIdleTimeout() > 0 && time.Since(cookie.LastSeen) >= getLastSeenWriteInterval() {
+		return true
+	}
+
+	key, err := getCurrentSigningKey()
+
+	return err == nil && key.ID != cookie.signingKeyID
+}
+
+// RevokeSignedSession adds the session of the given signed session cookie to the revocation list.
+// The session is kept on the revocation list until the latest expiry of a renewed copy of its token.
+func RevokeSignedSession(cookie *Cookie) error {
+	if cookie == nil {
+		return errors.New("error: provided cookie is nil")
+	}
+
+	return revokeSignedSessionID(cookie.ID, getSignedSessionMaxExpiry(cookie.Created, cookie.Expires))
+}
+
+// RevokeSignedSessionByID adds the signed session with the given session ID (the 'sid' claim of the token) to the
+// revocation list. The creation time of the session is unknown, so the session is kept on the revocation list for
+// the maximum lifetime of a new session.
+func RevokeSignedSessionByID(id string) error {
+	if id == "" {
+		return errors.New("error: no session ID provided")
+	}
+
+	now := time.Now()
+
+	return revokeSignedSessionID(id, getSignedSessionMaxExpiry(now, now.AddDate(0, 0, GetCookieLifetime())))
+}
+
+// revokeSignedSessionID adds the signed session with the given session ID to the revocation list until the given time.
+func revokeSignedSessionID(id string, until time.Time) error {
+	err := updateRevocations(func(bucket Bucket) error {
+		return bucket.Put([]byte("sid:"+id), []byte(until.Format(time.RFC3339)))
+	})
+
+	if err == nil {
+		signing.mutex.Lock()
+		signing.revokedSessions[id] = until
+		signing.mutex.Unlock()
+	}
+
+	return err
+}
+
+// getSignedSessionMaxExpiry returns the latest expiry of a signed session with the given creation time and expiry.
+// With sliding expiration, renewed tokens of the session expire up to the maximum lifetime after the creation time.
+func getSignedSessionMaxExpiry(created time.Time, expires time.Time) time.Time {
+	if !GetCookieSlidingExpiration() {
+		return expires
+	}
+
+	if maxExpires := created.AddDate(0, 0, GetCookieMaxLifetime()); maxExpires.After(expires) {
+		return maxExpires
+	}
+
+	return expires
+}
+
+// RevokeSignedSessionsByUsername revokes all signed sessions of the user with the given username
+// that were issued until now.
+func RevokeSignedSessionsByUsername(username string) error {
+	return revokeSignedSessionsBefore(username, time.Now())
+}
+
+// RevokeAllSignedSessions revokes all signed sessions that were issued until now.
+func RevokeAllSignedSessions() error {
+	return revokeSignedSessionsBefore("", time.Now())
+}
+
+// revokeSignedSessionsBefore revokes all signed sessions of the given username (or all users if the username
+// is empty) that were issued before the given time.
+func revokeSignedSessionsBefore(username string, before time.Time) error {
+	// signed session tokens contain the time of issue in milliseconds. The cutoff is exclusive, so sessions that are
+	// issued right after the revocation (e.g. after a password change) remain valid.
+	before = before.Truncate(time.Millisecond)
+
+	err := updateRevocations(func(bucket Bucket) error {
+		return bucket.Put([]byte("user:"+username), []byte(before.Format(time.RFC3339Nano)))
+	})
+
+	if err == nil {
+		signing.mutex.Lock()
+		signing.revokedBefore[username] = before
+		signing.mutex.Unlock()
+	}
+
+	return err
+}
+
+// updateRevocations runs the given update function on the revocation bucket and removes expired
+// session revocations in the same transaction.
+func updateRevocations(update func(bucket Bucket) error) error {
+	loadSigningState(false)
+
+	return store.Update(func(tx Tx) error {
+		bucket := tx.Bucket("revokedSessions")
+
+		var expiredKeys [][]byte
+
+		_ = bucket.ForEach(func(key, value []byte) error {
+			expires, err := time.Parse(time.RFC3339, string(value))
+
+			if strings.HasPrefix(string(key), "sid:") && err == nil && expires.Before(time.Now()) {
+				expiredKeys = append(expiredKeys, key)
+			}
+
+			return nil
+		})
+
+		for _, key := range expiredKeys {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return update(bucket)
+	})
+}
+
+// isSignedSessionRevoked returns true if the session of the given claims is on the revocation list.
+func isSignedSessionRevoked(claims *SignedSessionClaims) bool {
+	loadSigningState(false)
+
+	signing.mutex.RLock()
+	defer signing.mutex.RUnlock()
+
+	if _, ok := signing.revokedSessions[claims.SessionID]; ok {
+		return true
+	}
+
+	issuedAt := time.UnixMilli(claims.IssuedAt)
+
+	for _, username := range []string{"", claims.Username} {
+		if before, ok := signing.revokedBefore[username]; ok && issuedAt.Before(before) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetSigningKeys returns all signing keys in the database, sorted by creation time.
+func GetSigningKeys() ([]SigningKey, error) {
+	var keys []SigningKey
+
+	err := store.View(func(tx Tx) error {
+		bucket := tx.Bucket("signingKeys")
+
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, value []byte) error {
+			key := SigningKey{}
+
+			if err := json.Unmarshal(value, &key); err != nil {
+				return err
+			}
+
+			keys = append(keys, key)
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created.Before(keys[j].Created)
+	})
+
+	return keys, nil
+}
+
+// RotateSigningKey retires the current signing key and creates a new one. Tokens signed with the retired key are
+// accepted for the given grace period and re-signed with the new key upon their next use.
+// Retired keys with an expired grace period are deleted. Returns the new SigningKey.
+func RotateSigningKey(gracePeriod time.Duration) (*SigningKey, error) {
+	newKey, err := generateSigningKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.Update(func(tx Tx) error {
+		bucket := tx.Bucket("signingKeys")
+
+		now := time.Now()
+		var expiredKeys [][]byte
+
+		err = bucket.ForEach(func(k, value []byte) error {
+			key := SigningKey{}
+
+			if err := json.Unmarshal(value, &key); err != nil {
+				return err
+			}
+
+			if key.Retired.IsZero() {
+				key.Retired = now
+				key.GraceUntil = now.Add(gracePeriod)
+
+				buffer, err := json.Marshal(key)
+
+				if err != nil {
+					return err
+				}
+
+				return bucket.Put(k, buffer)
+			} else if key.GraceUntil.Before(now) {
+				expiredKeys = append(expiredKeys, k)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expiredKeys {
+			if err = bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		buffer, err := json.Marshal(newKey)
+
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(newKey.ID), buffer)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	loadSigningState(true)
+
+	return newKey, nil
+}
+
+// getCurrentSigningKey returns the current (not retired) signing key.
+// If no signing key exists yet, a new signing key is created.
+func getCurrentSigningKey() (*SigningKey, error) {
+	loadSigningState(false)
+
+	signing.mutex.RLock()
+
+	for i := range signing.keys {
+		if signing.keys[i].Retired.IsZero() {
+			key := signing.keys[i]
+			signing.mutex.RUnlock()
+
+			return &key, nil
+		}
+	}
+
+	signing.mutex.RUnlock()
+
+	if err := createSigningKeyIfMissing(); err != nil {
+		return nil, fmt.Errorf("could not create signing key: %s", err)
+	}
+
+	return getCurrentSigningKey()
+}
+
+// createSigningKeyIfMissing creates a new signing key if no current (not retired) signing key exists.
+// The check and the creation happen in the same transaction, so concurrent calls create only one key.
+func createSigningKeyIfMissing() error {
+	newKey, err := generateSigningKey()
+
+	if err != nil {
+		return err
+	}
+
+	err = store.Update(func(tx Tx) error {
+		bucket := tx.Bucket("signingKeys")
+
+		currentKeyExists := false
+
+		err := bucket.ForEach(func(k, value []byte) error {
+			key := SigningKey{}
+
+			if err := json.Unmarshal(value, &key); err != nil {
+				return err
+			}
+
+			if key.Retired.IsZero() {
+				currentKeyExists = true
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if currentKeyExists {
+			return nil
+		}
+
+		buffer, err := json.Marshal(newKey)
+
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(newKey.ID), buffer)
+	})
+
+	if err == nil {
+		loadSigningState(true)
+	}
+
+	return err
+}
+
+// getSigningKey returns the signing key with the given ID if it is the current signing key
+// or a retired signing key within its grace period. Returns nil otherwise.
+// An unknown key ID forces a reload of the signing state at most once per signingStateRefreshInterval,
+// so tokens with arbitrary key IDs cannot cause a database read on every request.
+func getSigningKey(id string) *SigningKey {
+	for _, forceReload := range []bool{false, true} {
+		if forceReload && !allowForcedReload() {
+			return nil
+		}
+
+		loadSigningState(forceReload)
+
+		signing.mutex.RLock()
+
+		for i := range signing.keys {
+			key := signing.keys[i]
+
+			if key.ID == id {
+				signing.mutex.RUnlock()
+
+				if key.Retired.IsZero() || key.GraceUntil.After(time.Now()) {
+					return &key
+				}
+
+				return nil
+			}
+		}
+
+		signing.mutex.RUnlock()
+	}
+
+	return nil
+}
+
+// allowForcedReload returns true and records the time if no reload was forced by an unknown signing key ID
+// within the last signingStateRefreshInterval.
+func allowForcedReload() bool {
+	signing.mutex.Lock()
+	defer signing.mutex.Unlock()
+
+	if time.Since(signing.forcedReload) < signingStateRefreshInterval {
+		return false
+	}
+
+	signing.forcedReload = time.Now()
+
+	return true
+}
+
+// loadSigningState loads the signing keys and the revocation list from the database into memory
+// if the in-memory copy is older than signingStateRefreshInterval or forceReload is true.
+func loadSigningState(forceReload bool) {
+	signing.mutex.RLock()
+	upToDate := !forceReload && time.Since(signing.loaded) < signingStateRefreshInterval
+	signing.mutex.RUnlock()
+
+	if upToDate {
+		return
+	}
+
+	keys, err := GetSigningKeys()
+
+	if err != nil {
+		// keep the previously loaded state
+		appLog.Printf("error: could not load the signing keys from the database: %s\n", err)
+		return
+	}
+
+	revokedSessions := make(map[string]time.Time)
+	revokedBefore := make(map[string]time.Time)
+
+	err = store.View(func(tx Tx) error {
+		bucket := tx.Bucket("revokedSessions")
+
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			timestamp, err := time.Parse(time.RFC3339Nano, string(value))
+
+			if err != nil {
+				return nil
+			}
+
+			if sessionId, ok := strings.CutPrefix(string(key), "sid:"); ok {
+				revokedSessions[sessionId] = timestamp
+			} else if username, ok := strings.CutPrefix(string(key), "user:"); ok {
+				revokedBefore[username] = timestamp
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		appLog.Printf("error: could not load the revoked sessions from the database: %s\n", err)
+		return
+	}
+
+	signing.mutex.Lock()
+	signing.keys = keys
+	signing.revokedSessions = revokedSessions
+	signing.revokedBefore = revokedBefore
+	signing.loaded = time.Now()
+	signing.mutex.Unlock()
+}
+
+// invalidateSigningState reloads the signing keys and the revocation list upon their next use,
+// e.g. after they were changed by another server.
+func invalidateSigningState() {
+	signing.mutex.Lock()
+	defer signing.mutex.Unlock()
+
+	signing.loaded = time.Time{}
+}
+
+// generateSigningKey generates a new random 256 bit signing key.
+func generateSigningKey() (*SigningKey, error) {
+	id, err := GenerateRandomBytes(4)
+
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := GenerateRandomBytes(32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:      hex.EncodeToString(id),
+		Secret:  secret,
+		Created: time.Now(),
+	}, nil
+}
+
+// computeSignature computes the HMAC-SHA256 signature of the given unsigned token.
+func computeSignature(secret []byte, unsignedToken string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsignedToken))
+
+	return mac.Sum(nil)
+}
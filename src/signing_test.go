@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRevokeSignedSessionsByUsername(t *testing.T) {
+	previousStore := store
+	store = NewMemoryStore()
+
+	t.Cleanup(func() {
+		store = previousStore
+		resetSigningState()
+	})
+
+	loadSigningState(true)
+
+	sign := func() string {
+		t.Helper()
+
+		token, err := SignSession(&Cookie{ID: GenerateSessionID(), Username: "alice", Expires: time.Now().Add(time.Hour)})
+
+		if err != nil {
+			t.Fatalf("SignSession: %s", err)
+		}
+
+		return token
+	}
+
+	revokedToken := sign()
+	otherToken, err := SignSession(&Cookie{ID: GenerateSessionID(), Username: "bob", Expires: time.Now().Add(time.Hour)})
+
+	if err != nil {
+		t.Fatalf("SignSession: %s", err)
+	}
+
+	// wait for the next millisecond, sessions issued within the millisecond of the revocation are not revoked
+	time.Sleep(2 * time.Millisecond)
+
+	if err = RevokeSignedSessionsByUsername("alice"); err != nil {
+		t.Fatalf("RevokeSignedSessionsByUsername: %s", err)
+	}
+
+	// a session issued right after the revocation has to be valid, e.g. after a password change
+	newToken := sign()
+
+	if _, err = VerifySignedSession(revokedToken); err == nil {
+		t.Errorf("the session issued before the revocation is still valid")
+	}
+
+	if _, err = VerifySignedSession(newToken); err != nil {
+		t.Errorf("the session issued after the revocation is invalid: %s", err)
+	}
+
+	if _, err = VerifySignedSession(otherToken); err != nil {
+		t.Errorf("the session of another user is invalid: %s", err)
+	}
+}
+
+// resetSigningState drops the signing keys and revocations loaded from the store of a test.
+func resetSigningState() {
+	signing.mutex.Lock()
+	defer signing.mutex.Unlock()
+
+	signing.keys = nil
+	signing.revokedSessions = make(map[string]time.Time)
+	signing.revokedBefore = make(map[string]time.Time)
+	signing.loaded = time.Time{}
+}
+
+func TestRevokeSignedSessionUntilMaxLifetime(t *testing.T) {
+	previousStore := store
+	previousCookies := config.Cookies
+	store = NewMemoryStore()
+	config.Cookies.SlidingExpiration = true
+	config.Cookies.MaxLifetime = 30
+
+	t.Cleanup(func() {
+		store = previousStore
+		config.Cookies = previousCookies
+		resetSigningState()
+	})
+
+	loadSigningState(true)
+
+	created := time.Now().Truncate(time.Second)
+	cookie := &Cookie{ID: GenerateSessionID(), Username: "alice", Created: created, Expires: created.Add(time.Hour)}
+
+	if err := RevokeSignedSession(cookie); err != nil {
+		t.Fatalf("RevokeSignedSession: %s", err)
+	}
+
+	// a renewed copy of the revoked session expires after the presented token
+	renewed := *cookie
+	renewed.Expires = created.AddDate(0, 0, 7)
+	token, err := SignSession(&renewed)
+
+	if err != nil {
+		t.Fatalf("SignSession: %s", err)
+	}
+
+	if _, err = VerifySignedSession(token); err == nil {
+		t.Errorf("a renewed copy of the revoked session is valid")
+	}
+
+	signing.mutex.Lock()
+	until := signing.revokedSessions[cookie.ID]
+	signing.mutex.Unlock()
+
+	if expected := created.AddDate(0, 0, 30); !until.Equal(expected) {
+		t.Errorf("the session is revoked until %s, expected %s", until, expected)
+	}
+}
+
+func TestRevokeSignedSessionByID(t *testing.T) {
+	previousStore := store
+	store = NewMemoryStore()
+
+	t.Cleanup(func() {
+		store = previousStore
+		resetSigningState()
+	})
+
+	loadSigningState(true)
+
+	cookie := &Cookie{ID: GenerateSessionID(), Username: "alice", Created: time.Now(), Expires: time.Now().Add(time.Hour)}
+	token, err := SignSession(cookie)
+
+	if err != nil {
+		t.Fatalf("SignSession: %s", err)
+	}
+
+	if err = RevokeSignedSessionByID(""); err == nil {
+		t.Errorf("RevokeSignedSessionByID accepted an empty session ID")
+	}
+
+	if err = RevokeSignedSessionByID(cookie.ID); err != nil {
+		t.Fatalf("RevokeSignedSessionByID: %s", err)
+	}
+
+	if _, err = VerifySignedSession(token); err == nil {
+		t.Errorf("the revoked session is still valid")
+	}
+}
+
+func TestSignedSessionIdleTimeout(t *testing.T) {
+	previousStore := store
+	previousCookies := config.Cookies
+	store = NewMemoryStore()
+	config.Cookies.IdleTimeout = 30
+
+	t.Cleanup(func() {
+		store = previousStore
+		config.Cookies = previousCookies
+		resetSigningState()
+	})
+
+	loadSigningState(true)
+
+	cookie := &Cookie{ID: GenerateSessionID(), Username: "alice", Created: time.Now(), Expires: time.Now().Add(time.Hour)}
+	token, err := SignSession(cookie)
+
+	if err != nil {
+		t.Fatalf("SignSession: %s", err)
+	}
+
+	verified, err := VerifyCookie(token)
+
+	if err != nil {
+		t.Fatalf("VerifyCookie of a fresh token: %s", err)
+	}
+
+	if NeedsResigning(verified) {
+		t.Errorf("a fresh token needs re-signing")
+	}
+
+	// a token that was re-signed within the write interval is still active
+	verified.LastSeen = time.Now().Add(-getLastSeenWriteInterval())
+
+	if !NeedsResigning(verified) {
+		t.Errorf("a token older than the write interval of the last activity does not need re-signing")
+	}
+
+	// a token that was not re-signed within the idle timeout is rejected
+	key, err := getCurrentSigningKey()
+
+	if err != nil {
+		t.Fatalf("getCurrentSigningKey: %s", err)
+	}
+
+	payload, err := json.Marshal(SignedSessionClaims{
+		SessionID: cookie.ID,
+		Username:  cookie.Username,
+		Created:   cookie.Created.Unix(),
+		IssuedAt:  time.Now().Add(-31 * time.Minute).UnixMilli(),
+		Expires:   cookie.Expires.Unix(),
+		KeyID:     key.ID,
+	})
+
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+
+	idleToken := signedSessionPrefix + base64.RawURLEncoding.EncodeToString(payload)
+	idleToken += "." + base64.RawURLEncoding.EncodeToString(computeSignature(key.Secret, idleToken))
+
+	if _, err = VerifySignedSession(idleToken); err != nil {
+		t.Fatalf("VerifySignedSession of the idle token: %s", err)
+	}
+
+	if _, err = VerifyCookie(idleToken); err == nil {
+		t.Errorf("VerifyCookie accepted a token issued before the idle timeout")
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// This file handles single sign-on (SSO) across multiple parent domains. The login happens on the primary
+// domain ([Server] domain). Secondary domains redirect unauthenticated users to /sso/authorize on the primary
+// domain, which issues a one-time, short-lived code. The /sso/consume route on the secondary domain exchanges
+// the code for a cookie that is scoped to the secondary domain. Before redirecting to /sso/authorize, the secondary
+// domain sets a short-lived state cookie. The code is bound to the state and /sso/consume requires the state cookie,
+// so an attacker cannot log a victim into the account of the attacker with a code issued to the attacker (login CSRF).
+
+// SSOCode is the structure for the database representation of a one-time SSO code
+type SSOCode struct {
+	Username   string    `json:"username"`
+	Domain     string    `json:"domain"`     // Domain :: secondary domain the code was issued for
+	AuthMethod string    `json:"authMethod"` // AuthMethod :: authentication method of the session on the primary domain
+	State      string    `json:"state"`      // State :: SHA-256 hash of the state of the secondary domain
+	Expires    time.Time `json:"expires"`
+}
+
+const (
+	// ssoEvictedSessionsHeader is the header of the /sso/consume redirect that contains the comma separated IDs of the
+	// sessions that were evicted by the session limit (like 'evictedSessions' of the login response)
+	ssoEvictedSessionsHeader = "X-Evicted-Sessions"
+
+	// ssoStateCookieName is the name of the state cookie on the secondary domain
+	ssoStateCookieName = "Nginx-Auth-Server-SSO-State"
+
+	// ssoStateLifetime is the time the user has to log in on the primary domain
+	ssoStateLifetime = 10 * time.Minute
+)
+
+// ssoAuthorize handles the /sso/authorize route on the primary domain. If the user is authenticated on the
+// primary domain, a one-time code bound to the 'state' query param is issued and the user is redirected to
+// /sso/consume on the host of the 'callback' query param. If the user is not authenticated, the user is redirected
+// to the login page.
+func ssoAuthorize(c *gin.Context) {
+	if !GetSSOEnabled() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	state := c.Query("state")
+
+	if state == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing state"})
+		return
+	}
+
+	callback, err := url.Parse(c.Query("callback"))
+
+	if err != nil || callback.Hostname() == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid callback URL"})
+		return
+	}
+
+	domain := getSSODomain(callback.Hostname())
+
+	if domain == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "callback URL is not on an allowed SSO domain"})
+		return
+	}
+
+	token, err := c.Cookie("Nginx-Auth-Server-Token")
+
+	if err == nil {
+		var cookie *Cookie
+
+		if cookie, err = VerifyCookie(token); err == nil {
+			code, err := CreateSSOCode(cookie.Username, domain, cookie.AuthMethod, state)
+
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not create SSO code"})
+				appLog.Printf("error: could not create SSO code for user with username '%s': %s\n", cookie.Username, err)
+				return
+			}
+
+			// the code must not be sent unencrypted if cookies are secure
+			scheme := callback.Scheme
+
+			if GetCookieSecure() {
+				scheme = "https"
+			}
+
+			consumeUrl := url.URL{
+				Scheme:   scheme,
+				Host:     callback.Host,
+				Path:     "/sso/consume",
+				RawQuery: url.Values{"code": {code}, "callback": {callback.String()}}.Encode(),
+			}
+
+			c.Redirect(http.StatusFound, consumeUrl.String())
+			return
+		}
+	}
+
+	// user is not authenticated on the primary domain, the login page redirects back after a successful login
+	c.Redirect(http.StatusFound, "/login?callback="+url.QueryEscape(c.Request.URL.RequestURI()))
+}
+
+// ssoConsume handles the /sso/consume route on a secondary domain. The one-time code in the 'code' query param
+// is exchanged for a cookie scoped to the secondary domain and the user is redirected to the 'callback' query param.
+// The code must have been issued for the state in the state cookie of the secondary domain.
+func ssoConsume(c *gin.Context) {
+	if !GetSSOEnabled() {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	host, _, _ := GetOriginalRequestFromContext(c)
+	domain := getSSODomain(host)
+
+	if domain == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "host is not on an allowed SSO domain"})
+		return
+	}
+
+	callback, err := url.Parse(c.Query("callback"))
+
+	if err != nil || getSSODomain(callback.Hostname()) != domain {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid callback URL"})
+		return
+	}
+
+	state, err := c.Cookie(ssoStateCookieName)
+
+	if err != nil || state == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing SSO state"})
+		authLog.Printf("SSO login for domain '%s' and client IP '%s' was rejected, the state cookie is missing\n", domain, GetClientIpFromContext(c))
+		return
+	}
+
+	// the state can only be used once
+	setSSOStateCookie(c, domain, "", -1)
+
+	code, err := ConsumeSSOCode(c.Query("code"), domain, state)
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired SSO code"})
+		authLog.Printf("invalid SSO code for domain '%s' and client IP '%s': %s\n", domain, GetClientIpFromContext(c), err)
+		return
+	}
+
+	clientIp := GetClientIpFromContext(c)
+
+	// the user might have been disabled after the code was issued
+	if disabled, err := IsUserDisabled(code.Username); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not look up user"})
+		appLog.Printf("error: could not look up user with username '%s'. %s\n", code.Username, err)
+		return
+	} else if disabled {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "account disabled"})
+		authLog.Printf("SSO login of user with username '%s' and client IP '%s' was rejected, the user is disabled\n", code.Username, clientIp)
+		return
+	}
+
+	evictedIds, ok := enforceSessionLimit(c, code.Username, clientIp)
+
+	if !ok {
+		return
+	}
+
+	if len(evictedIds) != 0 {
+		c.Header(ssoEvictedSessionsHeader, strings.Join(evictedIds, ","))
+	}
+
+	createAndSetAuthCookie(c, code.Username, domain, code.AuthMethod)
+	authLog.Printf("user with username '%s' and client IP '%s' logged in successfully on SSO domain '%s'\n", code.Username, clientIp, domain)
+
+	c.Redirect(http.StatusFound, callback.String())
+}
+
+// redirectToSSOAuthorize redirects the user to /sso/authorize on the primary domain if SSO is enabled and the
+// original host is on a secondary SSO domain. A new state is set as state cookie on the secondary domain and attached
+// as the 'state' query param. Returns false if the user was not redirected.
+func redirectToSSOAuthorize(c *gin.Context) bool {
+	authorizeUrl := getSSOAuthorizeUrl(c)
+
+	if authorizeUrl == "" {
+		return false
+	}
+
+	random, err := GenerateRandomBytes(32)
+
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "could not create SSO state"})
+		appLog.Printf("error: could not create SSO state: %s\n", err)
+		return true
+	}
+
+	state := hex.EncodeToString(random)
+	host, _, _ := GetOriginalRequestFromContext(c)
+
+	setSSOStateCookie(c, getSSODomain(host), state, int(ssoStateLifetime.Seconds()))
+
+	c.Redirect(http.StatusFound, authorizeUrl+"&state="+url.QueryEscape(state))
+
+	return true
+}
+
+// setSSOStateCookie sets the state cookie for /sso/consume on the given secondary domain. A negative maxAge deletes
+// the cookie.
+func setSSOStateCookie(c *gin.Context, domain string, state string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     ssoStateCookieName,
+		Value:    state,
+		MaxAge:   maxAge,
+		Domain:   domain,
+		Path:     "/sso/consume",
+		HttpOnly: true,
+		Secure:   GetCookieSecure(),
+		// the state cookie has to be sent on the top-level redirect from the primary domain
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// getSSOAuthorizeUrl returns the URL of /sso/authorize on the primary domain if SSO is enabled and the given
+// host is on a secondary SSO domain. The original URL of the request is attached as the 'callback' query param.
+// Returns an empty string otherwise.
+func getSSOAuthorizeUrl(c *gin.Context) string {
+	if !GetSSOEnabled() {
+		return ""
+	}
+
+	host, path, _ := GetOriginalRequestFromContext(c)
+
+	if getSSODomain(host) == "" {
+		return ""
+	}
+
+	proto := ""
+
+	if TrustForwardedHeaders(c) {
+		proto = c.GetHeader("X-Forwarded-Proto")
+	}
+
+	if proto == "" {
+		proto = "https"
+
+		if !GetCookieSecure() {
+			proto = "http"
+		}
+	}
+
+	// a callback given to /login on the secondary domain takes precedence over the original request
+	callback := c.Query("callback")
+
+	if callback == "" {
+		callback = fmt.Sprintf("%s://%s%s", proto, host, path)
+	}
+
+	return fmt.Sprintf("%s/sso/authorize?callback=%s", strings.TrimSuffix(GetSSOPrimaryUrl(), "/"), url.QueryEscape(callback))
+}
+
+// getSSODomain returns the configured secondary SSO domain the given host belongs to.
+// Returns an empty string if the host does not belong to any secondary SSO domain.
+func getSSODomain(host string) string {
+	for _, domain := range GetSSODomains() {
+		domain = strings.TrimSpace(domain)
+
+		if domain != "" && (strings.EqualFold(host, domain) || strings.HasSuffix(strings.ToLower(host), "."+strings.ToLower(domain))) {
+			return domain
+		}
+	}
+
+	return ""
+}
+
+// CreateSSOCode creates a one-time SSO code for the given username, secondary domain, authentication method and
+// state of the secondary domain and saves it to the database. Only the SHA-256 hashes of the code and the state are
+// saved. Returns the plaintext code.
+func CreateSSOCode(username string, domain string, authMethod string, state string) (string, error) {
+	random, err := GenerateRandomBytes(32)
+
+	if err != nil {
+		return "", err
+	}
+
+	code := hex.EncodeToString(random)
+
+	ssoCode := SSOCode{
+		Username:   username,
+		Domain:     domain,
+		AuthMethod: authMethod,
+		State:      HashSHA256(state),
+		Expires:    time.Now().Add(time.Duration(GetSSOCodeLifetime()) * time.Second),
+	}
+
+	err = store.Update(func(tx Tx) error {
+		bucket := tx.Bucket("ssoCodes")
+
+		// remove expired codes that were never consumed
+		var expiredKeys [][]byte
+
+		_ = bucket.ForEach(func(key, value []byte) error {
+			expiredCode := SSOCode{}
+
+			if json.Unmarshal(value, &expiredCode) != nil || expiredCode.Expires.Before(time.Now()) {
+				expiredKeys = append(expiredKeys, key)
+			}
+
+			return nil
+		})
+
+		for _, key := range expiredKeys {
+			if err = bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		buffer, err := json.Marshal(ssoCode)
+
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(HashSHA256(code)), buffer)
+	})
+
+	return code, err
+}
+
+// ConsumeSSOCode looks up the given plaintext SSO code, deletes it from the database and returns it.
+// Returns nil and an error if the code was not found, is expired or was issued for another domain or state.
+func ConsumeSSOCode(code string, domain string, state string) (*SSOCode, error) {
+	if code == "" {
+		return nil, errors.New("error: no SSO code provided")
+	}
+
+	var ssoCode *SSOCode
+
+	err := store.Update(func(tx Tx) error {
+		bucket := tx.Bucket("ssoCodes")
+
+		if bucket == nil {
+			return errors.New("error: SSO code not found")
+		}
+
+		key := []byte(HashSHA256(code))
+		value := bucket.Get(key)
+
+		if value == nil {
+			return errors.New("error: SSO code not found")
+		}
+
+		if err := json.Unmarshal(value, &ssoCode); err != nil {
+			return err
+		}
+
+		// the code can only be used once
+		return bucket.Delete(key)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if ssoCode.Expires.Before(time.Now()) {
+		return nil, errors.New("error: SSO code is expired")
+	}
+
+	if !strings.EqualFold(ssoCode.Domain, domain) {
+		return nil, errors.New("error: SSO code was issued for another domain")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(ssoCode.State), []byte(HashSHA256(state))) != 1 {
+		return nil, errors.New("error: SSO code was issued for another state")
+	}
+
+	return ssoCode, nil
+}
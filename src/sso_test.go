@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSSOConsumeRequiresState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previousConfig := *config
+	previousStore := store
+	store = NewMemoryStore()
+
+	t.Cleanup(func() {
+		*config = previousConfig
+		store = previousStore
+		PurgeCookieCache()
+	})
+
+	config.SSO.Enabled = true
+	config.SSO.PrimaryURL = "https://auth.example.com"
+	config.SSO.Domains = []string{"example.io"}
+	config.Cookies.Mode = "database"
+
+	if err := store.SaveUser(User{Username: "alice"}); err != nil {
+		t.Fatalf("SaveUser: %s", err)
+	}
+
+	router := gin.New()
+	router.GET("/sso/consume", ssoConsume)
+
+	consume := func(code string, state string) *httptest.ResponseRecorder {
+		query := url.Values{"code": {code}, "callback": {"https://app.example.io/"}}
+		request := httptest.NewRequest(http.MethodGet, "/sso/consume?"+query.Encode(), nil)
+		request.Header.Set("X-Original-Host", "app.example.io")
+
+		if state != "" {
+			request.AddCookie(&http.Cookie{Name: ssoStateCookieName, Value: state})
+		}
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		return recorder
+	}
+
+	tests := []struct {
+		name   string
+		state  string
+		cookie string
+		status int
+	}{
+		{"missing state cookie", "state-a", "", http.StatusUnauthorized},
+		{"state of another user", "state-a", "state-b", http.StatusUnauthorized},
+		{"matching state", "state-a", "state-a", http.StatusFound},
+	}
+
+	for _, test := range tests {
+		code, err := CreateSSOCode("alice", "example.io", "local", test.state)
+
+		if err != nil {
+			t.Fatalf("CreateSSOCode: %s", err)
+		}
+
+		recorder := consume(code, test.cookie)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.name, recorder.Code, test.status)
+		}
+
+		if test.status == http.StatusFound && !strings.Contains(strings.Join(recorder.Header().Values("Set-Cookie"), "\n"), "Nginx-Auth-Server-Token=") {
+			t.Errorf("%s: no auth cookie was set", test.name)
+		}
+	}
+}
+
+func TestSSOAuthorizeForcesHttps(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	previousConfig := *config
+	previousStore := store
+	store = NewMemoryStore()
+
+	t.Cleanup(func() {
+		*config = previousConfig
+		store = previousStore
+		PurgeCookieCache()
+	})
+
+	config.SSO.Enabled = true
+	config.SSO.PrimaryURL = "https://auth.example.com"
+	config.SSO.Domains = []string{"example.io"}
+	config.Cookies.Mode = "database"
+	config.Cookies.Secure = true
+
+	if err := store.SaveUser(User{Username: "alice"}); err != nil {
+		t.Fatalf("SaveUser: %s", err)
+	}
+
+	router := gin.New()
+	router.GET("/sso/authorize", ssoAuthorize)
+
+	// sign in on the primary domain
+	recorder := httptest.NewRecorder()
+	context, _ := gin.CreateTestContext(recorder)
+	context.Request = httptest.NewRequest(http.MethodGet, "/login", nil)
+	createAndSetAuthCookie(context, "alice", "example.com", "local")
+	authCookie := recorder.Result().Cookies()[0]
+
+	for _, state := range []string{"", "state-a"} {
+		query := url.Values{"callback": {"http://app.example.io/"}}
+
+		if state != "" {
+			query.Set("state", state)
+		}
+
+		request := httptest.NewRequest(http.MethodGet, "/sso/authorize?"+query.Encode(), nil)
+		request.AddCookie(authCookie)
+
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		if state == "" {
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("authorize without state: got status %d, want %d", recorder.Code, http.StatusBadRequest)
+			}
+
+			continue
+		}
+
+		if location := recorder.Header().Get("Location"); !strings.HasPrefix(location, "https://app.example.io/sso/consume?") {
+			t.Errorf("authorize redirected to '%s', want https://app.example.io/sso/consume", location)
+		}
+	}
+}
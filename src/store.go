@@ -0,0 +1,132 @@
+package main
+
+// This file defines the storage interface of the application. Users and sessions are accessed through typed
+// methods, all other buckets (API tokens, signing keys, SSO/OIDC codes, ...) through generic transactions.
+// The bbolt implementation is found in database.go, the in-memory implementation in store_memory.go and the Redis
+// implementation in store_redis.go.
+
+// UserStore stores the local users.
+type UserStore interface {
+	// GetUser returns the user with the given username. Returns nil and no error if the user was not found.
+	GetUser(username string) (*User, error)
+	GetUsers() ([]User, error)
+	SaveUser(user User) error
+	DeleteUser(username string) error
+}
+
+// SessionStore stores the sessions (cookies) of the database session mode. Sessions are identified by the
+// argon2 hash of the cookie value.
+type SessionStore interface {
+	// GetSession returns the session with the given hash. Returns nil and no error if the session was not found.
+	GetSession(hash string) (*Cookie, error)
+	GetSessions() ([]Cookie, error)
+	GetSessionsByUsername(username string) ([]Cookie, error)
+	SaveSession(cookie Cookie) error
+	DeleteSession(hash string) error
+	DeleteSessionsByUsername(username string) error
+	// DeleteSessionsWhere deletes all sessions matching the given filter and returns the deleted sessions.
+	DeleteSessionsWhere(filter func(cookie *Cookie) bool) ([]Cookie, error)
+	PurgeSessions() error
+}
+
+// Store is the storage of the application.
+type Store interface {
+	UserStore
+	SessionStore
+
+	// View executes the given function within a read-only transaction.
+	View(fn func(tx Tx) error) error
+	// Update executes the given function within a read-write transaction. The transaction is rolled back
+	// if the function returns an error. The function may be called multiple times if the transaction is retried
+	// (see redisStore.Update), so it must not have side effects outside the transaction.
+	Update(fn func(tx Tx) error) error
+	// Import saves the given users and sessions within a single transaction. If replace is true, all existing users
+	// and sessions are deleted within the same transaction.
+	Import(users []User, sessions []Cookie, replace bool) error
+
+	Close() error
+}
+
+// Tx is a transaction on the generic buckets of a Store.
+type Tx interface {
+	// Bucket returns the bucket with the given name. Read-write transactions create the bucket if it does not exist,
+	// read-only transactions return nil if the bucket does not exist.
+	Bucket(name string) Bucket
+	DeleteBucket(name string) error
+}
+
+// Bucket is a collection of key/value pairs. The keys are iterated in byte-sorted order.
+// The signatures match *bolt.Bucket.
+type Bucket interface {
+	Get(key []byte) []byte
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+	ForEach(fn func(key []byte, value []byte) error) error
+}
+
+// splitStore stores sessions, the generic buckets and optionally users in Redis. Users are stored in the bbolt
+// database otherwise.
+type splitStore struct {
+	UserStore
+	SessionStore
+
+	base  *boltStore
+	redis *redisStore
+}
+
+// store is the Store of the application. CLI commands open the database for every operation,
+// the server keeps the database open for its whole runtime (see runGin).
+var store Store = &boltStore{}
+
+// newStore returns the Store of the application for the given bbolt store. Sessions, the generic buckets and
+// optionally users are stored in Redis if Redis is enabled in section [Redis].
+func newStore(base *boltStore) Store {
+	if !GetRedisEnabled() {
+		return base
+	}
+
+	redis := newRedisStore()
+
+	s := &splitStore{
+		UserStore:    base,
+		SessionStore: redis,
+		base:         base,
+		redis:        redis,
+	}
+
+	if GetRedisUsers() {
+		s.UserStore = redis
+	}
+
+	return s
+}
+
+// View implements Store.
+func (s *splitStore) View(fn func(tx Tx) error) error {
+	return s.redis.View(fn)
+}
+
+// Update implements Store.
+func (s *splitStore) Update(fn func(tx Tx) error) error {
+	return s.redis.Update(fn)
+}
+
+// Import implements Store. Users stored in the bbolt database are imported within a separate transaction.
+func (s *splitStore) Import(users []User, sessions []Cookie, replace bool) error {
+	if s.UserStore == UserStore(s.redis) {
+		return s.redis.Import(users, sessions, replace)
+	}
+
+	if err := s.base.Import(users, nil, replace); err != nil {
+		return err
+	}
+
+	return s.redis.Import(nil, sessions, replace)
+}
+
+// Close implements Store.
+func (s *splitStore) Close() error {
+	_ = s.redis.Close()
+
+	return s.base.Close()
+}
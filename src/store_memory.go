@@ -0,0 +1,317 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// This file implements an in-memory Store. The in-memory store is not persisted and is intended for tests.
+
+// memoryStore is the in-memory implementation of Store.
+type memoryStore struct {
+	mutex    sync.RWMutex
+	users    map[string]User
+	sessions map[string]Cookie
+	buckets  map[string]memoryBucket
+}
+
+// memoryTx is the in-memory implementation of Tx. Read-write transactions operate on a copy of the buckets,
+// which replaces the buckets of the store once the transaction succeeded.
+type memoryTx struct {
+	buckets  map[string]memoryBucket
+	writable bool
+}
+
+// memoryBucket is the in-memory implementation of Bucket.
+type memoryBucket map[string][]byte
+
+// NewMemoryStore returns an empty in-memory Store.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		users:    make(map[string]User),
+		sessions: make(map[string]Cookie),
+		buckets:  make(map[string]memoryBucket),
+	}
+}
+
+// Close implements Store.
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// View implements Store.
+func (s *memoryStore) View(fn func(tx Tx) error) error {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return fn(&memoryTx{buckets: s.buckets})
+}
+
+// Update implements Store.
+func (s *memoryStore) Update(fn func(tx Tx) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	buckets := make(map[string]memoryBucket, len(s.buckets))
+
+	for name, bucket := range s.buckets {
+		buckets[name] = bucket.copy()
+	}
+
+	if err := fn(&memoryTx{buckets: buckets, writable: true}); err != nil {
+		return err
+	}
+
+	s.buckets = buckets
+
+	return nil
+}
+
+// Bucket implements Tx.
+func (t *memoryTx) Bucket(name string) Bucket {
+	bucket, ok := t.buckets[name]
+
+	if !ok {
+		if !t.writable {
+			return nil
+		}
+
+		bucket = make(memoryBucket)
+		t.buckets[name] = bucket
+	}
+
+	return bucket
+}
+
+// DeleteBucket implements Tx.
+func (t *memoryTx) DeleteBucket(name string) error {
+	delete(t.buckets, name)
+	return nil
+}
+
+// Get implements Bucket.
+func (b memoryBucket) Get(key []byte) []byte {
+	return b[string(key)]
+}
+
+// Put implements Bucket.
+func (b memoryBucket) Put(key []byte, value []byte) error {
+	b[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+// Delete implements Bucket.
+func (b memoryBucket) Delete(key []byte) error {
+	delete(b, string(key))
+	return nil
+}
+
+// ForEach implements Bucket.
+func (b memoryBucket) ForEach(fn func(key []byte, value []byte) error) error {
+	keys := make([]string, 0, len(b))
+
+	for key := range b {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, ok := b[key]
+
+		// the entry was deleted by fn
+		if !ok {
+			continue
+		}
+
+		if err := fn([]byte(key), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copy returns a copy of the bucket. The values are not copied, since they are never modified in place.
+func (b memoryBucket) copy() memoryBucket {
+	bucket := make(memoryBucket, len(b))
+
+	for key, value := range b {
+		bucket[key] = value
+	}
+
+	return bucket
+}
+
+// GetUser implements UserStore.
+func (s *memoryStore) GetUser(username string) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if user, ok := s.users[username]; ok {
+		return &user, nil
+	}
+
+	return nil, nil
+}
+
+// GetUsers implements UserStore.
+func (s *memoryStore) GetUsers() ([]User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var users []User
+
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	return users, nil
+}
+
+// SaveUser implements UserStore.
+func (s *memoryStore) SaveUser(user User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.users[user.Username] = user
+
+	return nil
+}
+
+// DeleteUser implements UserStore.
+func (s *memoryStore) DeleteUser(username string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.users, username)
+
+	return nil
+}
+
+// GetSession implements SessionStore.
+func (s *memoryStore) GetSession(hash string) (*Cookie, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if cookie, ok := s.sessions[hash]; ok {
+		return &cookie, nil
+	}
+
+	return nil, nil
+}
+
+// GetSessions implements SessionStore.
+func (s *memoryStore) GetSessions() ([]Cookie, error) {
+	return s.getSessions(func(cookie *Cookie) bool {
+		return true
+	}), nil
+}
+
+// GetSessionsByUsername implements SessionStore.
+func (s *memoryStore) GetSessionsByUsername(username string) ([]Cookie, error) {
+	return s.getSessions(func(cookie *Cookie) bool {
+		return cookie.Username == username
+	}), nil
+}
+
+// getSessions returns all sessions matching the given filter, sorted by hash like the bbolt implementation.
+func (s *memoryStore) getSessions(filter func(cookie *Cookie) bool) []Cookie {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var cookies []Cookie
+
+	for _, cookie := range s.sessions {
+		if filter(&cookie) {
+			cookies = append(cookies, cookie)
+		}
+	}
+
+	sort.Slice(cookies, func(i, j int) bool {
+		return cookies[i].Value < cookies[j].Value
+	})
+
+	return cookies
+}
+
+// SaveSession implements SessionStore.
+func (s *memoryStore) SaveSession(cookie Cookie) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sessions[cookie.Value] = cookie
+
+	return nil
+}
+
+// DeleteSession implements SessionStore.
+func (s *memoryStore) DeleteSession(hash string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.sessions, hash)
+
+	return nil
+}
+
+// DeleteSessionsByUsername implements SessionStore.
+func (s *memoryStore) DeleteSessionsByUsername(username string) error {
+	_, err := s.DeleteSessionsWhere(func(cookie *Cookie) bool {
+		return cookie.Username == username
+	})
+
+	return err
+}
+
+// DeleteSessionsWhere implements SessionStore.
+func (s *memoryStore) DeleteSessionsWhere(filter func(cookie *Cookie) bool) ([]Cookie, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var cookies []Cookie
+
+	for hash, cookie := range s.sessions {
+		if filter(&cookie) {
+			cookies = append(cookies, cookie)
+			delete(s.sessions, hash)
+		}
+	}
+
+	return cookies, nil
+}
+
+// Import implements Store.
+func (s *memoryStore) Import(users []User, sessions []Cookie, replace bool) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if replace {
+		s.users = make(map[string]User)
+		s.sessions = make(map[string]Cookie)
+	}
+
+	for _, user := range users {
+		s.users[user.Username] = user
+	}
+
+	for _, cookie := range sessions {
+		s.sessions[cookie.Value] = cookie
+	}
+
+	return nil
+}
+
+// PurgeSessions implements SessionStore.
+func (s *memoryStore) PurgeSessions() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sessions = make(map[string]Cookie)
+
+	return nil
+}
@@ -0,0 +1,865 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// This file implements the Redis store, which allows multiple servers to share sessions, the generic buckets
+// (API tokens, signing keys, revoked signed sessions, SSO/OIDC codes) and, optionally, users.
+// The keys are prefixed with the configured key prefix:
+//
+//	user:<username>           JSON of the user
+//	session:<hash>            JSON of the session, expires together with the session
+//	user-sessions:<username>  set of the session hashes of the user
+//	bucket:<name>             hash of the key/value pairs of a generic bucket
+//
+// Users and sessions are encrypted like in the bbolt database if encryption at rest is enabled (see encryption.go),
+// using the bucket names 'users' and 'cookies' and the username or the session hash as additional data.
+//
+// Every server caches sessions in memory (see cache.go). Therefore, deleted sessions and changed users are
+// announced on the channel 'revocations', so all servers drop them from their caches immediately. Changed generic
+// buckets are announced as well, so all servers reload the signing keys and revoked signed sessions.
+
+const (
+	// redisRevocationsChannel is the name of the channel the revocations are published on (without key prefix)
+	redisRevocationsChannel = "revocations"
+
+	// messages published on redisRevocationsChannel
+	redisRevokeSessionMessage = "session "
+	redisRevokeUserMessage    = "user "
+	redisPurgeSessionsMessage = "purge"
+	redisUsersChangedMessage  = "users"
+	redisBucketChangedMessage = "bucket "
+
+	// redisTransactionAttempts is the maximum number of attempts of a read-write transaction on the generic buckets,
+	// which is retried if a bucket it used was modified concurrently
+	redisTransactionAttempts = 10
+)
+
+// redisStore is the Redis implementation of Store.
+type redisStore struct {
+	client *redisClient
+	prefix string
+}
+
+// redisTx is the Redis implementation of Tx. Read-write transactions run on a dedicated connection, WATCH the buckets
+// they use and apply their changes with MULTI/EXEC once the transaction succeeded (optimistic locking).
+type redisTx struct {
+	store   *redisStore
+	conn    *redisConn // conn :: nil for read-only transactions
+	buckets map[string]*redisBucket
+	// err is the first error of a command sent by the transaction
+	err error
+}
+
+// redisBucket is the Redis implementation of Bucket. Values are read on demand and cached for the transaction.
+type redisBucket struct {
+	tx  *redisTx
+	key string
+	// values contains the values read or written by the transaction, nil values are missing or deleted
+	values map[string][]byte
+	// loaded is true if all values of the bucket were read
+	loaded bool
+	// changed contains the keys written or deleted by the transaction
+	changed map[string]bool
+	// cleared is true if the bucket was deleted by the transaction
+	cleared bool
+}
+
+// newRedisStore returns a redisStore for the Redis server configured in section [Redis].
+func newRedisStore() *redisStore {
+	return &redisStore{
+		client: newRedisClient(GetRedisAddress(), GetRedisPassword(), GetRedisDatabase(), time.Duration(GetRedisTimeout())*time.Second),
+		prefix: GetRedisKeyPrefix(),
+	}
+}
+
+// userKey returns the key of the user with the given username.
+func (s *redisStore) userKey(username string) string {
+	return s.prefix + "user:" + username
+}
+
+// sessionKey returns the key of the session with the given hash.
+func (s *redisStore) sessionKey(hash string) string {
+	return s.prefix + "session:" + hash
+}
+
+// userSessionsKey returns the key of the set of session hashes of the user with the given username.
+func (s *redisStore) userSessionsKey(username string) string {
+	return s.prefix + "user-sessions:" + username
+}
+
+// bucketKey returns the key of the generic bucket with the given name.
+func (s *redisStore) bucketKey(name string) string {
+	return s.prefix + "bucket:" + name
+}
+
+// publishCommand returns the command that publishes the given message on the revocations channel.
+func (s *redisStore) publishCommand(message string) []string {
+	return []string{"PUBLISH", s.prefix + redisRevocationsChannel, message}
+}
+
+// Close closes the connections to the Redis server.
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
+
+// GetUser implements UserStore.
+func (s *redisStore) GetUser(username string) (*User, error) {
+	reply, err := s.client.Do("GET", s.userKey(username))
+
+	if err != nil || reply == nil {
+		return nil, err
+	}
+
+	user := User{}
+
+	if err = unmarshalValue("users", []byte(username), reply.([]byte), &user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetUsers implements UserStore.
+func (s *redisStore) GetUsers() ([]User, error) {
+	keys, values, err := s.getValues(s.prefix + "user:*")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+
+	for i, value := range values {
+		user := User{}
+
+		if err = unmarshalValue("users", []byte(strings.TrimPrefix(keys[i], s.userKey(""))), value, &user); err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	return users, nil
+}
+
+// SaveUser implements UserStore.
+func (s *redisStore) SaveUser(user User) error {
+	buffer, err := marshalValue("users", []byte(user.Username), user)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Transaction(
+		[]string{"SET", s.userKey(user.Username), string(buffer)},
+		s.publishCommand(redisUsersChangedMessage),
+	)
+
+	return err
+}
+
+// DeleteUser implements UserStore.
+func (s *redisStore) DeleteUser(username string) error {
+	_, err := s.client.Transaction(
+		[]string{"DEL", s.userKey(username)},
+		s.publishCommand(redisUsersChangedMessage),
+	)
+
+	return err
+}
+
+// GetSession implements SessionStore.
+func (s *redisStore) GetSession(hash string) (*Cookie, error) {
+	reply, err := s.client.Do("GET", s.sessionKey(hash))
+
+	if err != nil || reply == nil {
+		return nil, err
+	}
+
+	cookie := Cookie{}
+
+	if err = unmarshalValue("cookies", []byte(hash), reply.([]byte), &cookie); err != nil {
+		return nil, err
+	}
+
+	return &cookie, nil
+}
+
+// GetSessions implements SessionStore.
+func (s *redisStore) GetSessions() ([]Cookie, error) {
+	keys, values, err := s.getValues(s.prefix + "session:*")
+
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(keys))
+
+	for i, key := range keys {
+		hashes[i] = strings.TrimPrefix(key, s.sessionKey(""))
+	}
+
+	return unmarshalSessions(hashes, values)
+}
+
+// GetSessionsByUsername implements SessionStore. Hashes of expired sessions are removed from the set of the user.
+func (s *redisStore) GetSessionsByUsername(username string) ([]Cookie, error) {
+	reply, err := s.client.Do("SMEMBERS", s.userSessionsKey(username))
+
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := redisStrings(reply)
+
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+
+	commands := make([][]string, len(hashes))
+
+	for i, hash := range hashes {
+		commands[i] = []string{"GET", s.sessionKey(hash)}
+	}
+
+	replies, err := s.client.Pipeline(commands...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var activeHashes []string
+	var values [][]byte
+	expired := []string{"SREM", s.userSessionsKey(username)}
+
+	for i, reply := range replies {
+		if reply == nil {
+			expired = append(expired, hashes[i])
+			continue
+		}
+
+		activeHashes = append(activeHashes, hashes[i])
+		values = append(values, reply.([]byte))
+	}
+
+	if len(expired) > 2 {
+		if _, err = s.client.Do(expired...); err != nil {
+			return nil, err
+		}
+	}
+
+	return unmarshalSessions(activeHashes, values)
+}
+
+// SaveSession implements SessionStore. The session expires together with the cookie.
+func (s *redisStore) SaveSession(cookie Cookie) error {
+	buffer, err := marshalValue("cookies", []byte(cookie.Value), cookie)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Transaction(
+		[]string{"SET", s.sessionKey(cookie.Value), string(buffer)},
+		[]string{"PEXPIREAT", s.sessionKey(cookie.Value), strconv.FormatInt(cookie.Expires.UnixMilli(), 10)},
+		[]string{"SADD", s.userSessionsKey(cookie.Username), cookie.Value},
+	)
+
+	return err
+}
+
+// DeleteSession implements SessionStore.
+func (s *redisStore) DeleteSession(hash string) error {
+	cookie, err := s.GetSession(hash)
+
+	if err != nil || cookie == nil {
+		return err
+	}
+
+	return s.deleteSessions([]Cookie{*cookie})
+}
+
+// DeleteSessionsByUsername implements SessionStore.
+func (s *redisStore) DeleteSessionsByUsername(username string) error {
+	reply, err := s.client.Do("SMEMBERS", s.userSessionsKey(username))
+
+	if err != nil {
+		return err
+	}
+
+	commands := [][]string{{"DEL", s.userSessionsKey(username)}}
+
+	for _, hash := range redisStrings(reply) {
+		commands = append(commands, []string{"DEL", s.sessionKey(hash)})
+	}
+
+	commands = append(commands, s.publishCommand(redisRevokeUserMessage+username))
+
+	_, err = s.client.Transaction(commands...)
+
+	return err
+}
+
+// DeleteSessionsWhere implements SessionStore.
+func (s *redisStore) DeleteSessionsWhere(filter func(cookie *Cookie) bool) ([]Cookie, error) {
+	sessions, err := s.GetSessions()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var cookies []Cookie
+
+	for i := range sessions {
+		if filter(&sessions[i]) {
+			cookies = append(cookies, sessions[i])
+		}
+	}
+
+	if len(cookies) == 0 {
+		return nil, nil
+	}
+
+	if err = s.deleteSessions(cookies); err != nil {
+		return nil, err
+	}
+
+	return cookies, nil
+}
+
+// PurgeSessions implements SessionStore.
+func (s *redisStore) PurgeSessions() error {
+	sessionKeys, err := s.client.Scan(s.prefix + "session:*")
+
+	if err != nil {
+		return err
+	}
+
+	indexKeys, err := s.client.Scan(s.prefix + "user-sessions:*")
+
+	if err != nil {
+		return err
+	}
+
+	var commands [][]string
+
+	for _, key := range append(sessionKeys, indexKeys...) {
+		commands = append(commands, []string{"DEL", key})
+	}
+
+	commands = append(commands, s.publishCommand(redisPurgeSessionsMessage))
+
+	_, err = s.client.Transaction(commands...)
+
+	return err
+}
+
+// Import implements Store. If replace is true, the users and sessions that exist before the transaction are deleted
+// within the transaction.
+func (s *redisStore) Import(users []User, sessions []Cookie, replace bool) error {
+	var commands [][]string
+
+	if replace {
+		for _, pattern := range []string{"user:*", "session:*", "user-sessions:*"} {
+			keys, err := s.client.Scan(s.prefix + pattern)
+
+			if err != nil {
+				return err
+			}
+
+			for _, key := range keys {
+				commands = append(commands, []string{"DEL", key})
+			}
+		}
+	}
+
+	for _, user := range users {
+		buffer, err := marshalValue("users", []byte(user.Username), user)
+
+		if err != nil {
+			return err
+		}
+
+		commands = append(commands, []string{"SET", s.userKey(user.Username), string(buffer)})
+	}
+
+	for _, cookie := range sessions {
+		buffer, err := marshalValue("cookies", []byte(cookie.Value), cookie)
+
+		if err != nil {
+			return err
+		}
+
+		commands = append(commands,
+			[]string{"SET", s.sessionKey(cookie.Value), string(buffer)},
+			[]string{"PEXPIREAT", s.sessionKey(cookie.Value), strconv.FormatInt(cookie.Expires.UnixMilli(), 10)},
+			[]string{"SADD", s.userSessionsKey(cookie.Username), cookie.Value},
+		)
+	}
+
+	commands = append(commands, s.publishCommand(redisUsersChangedMessage))
+
+	if replace {
+		commands = append(commands, s.publishCommand(redisPurgeSessionsMessage))
+	}
+
+	_, err := s.client.Transaction(commands...)
+
+	return err
+}
+
+// deleteSessions deletes the given sessions and publishes their revocation.
+func (s *redisStore) deleteSessions(cookies []Cookie) error {
+	var commands [][]string
+
+	for _, cookie := range cookies {
+		commands = append(commands,
+			[]string{"DEL", s.sessionKey(cookie.Value)},
+			[]string{"SREM", s.userSessionsKey(cookie.Username), cookie.Value},
+			s.publishCommand(redisRevokeSessionMessage+cookie.Value),
+		)
+	}
+
+	_, err := s.client.Transaction(commands...)
+
+	return err
+}
+
+// Rekey re-encrypts all users and sessions with the given master key, or decrypts them if masterKey is nil.
+// Values that are deleted in the meantime are skipped. Returns the number of re-encrypted values.
+func (s *redisStore) Rekey(masterKey *masterKey) (int, error) {
+	count := 0
+
+	for _, bucket := range []struct{ name, keyPrefix string }{{"users", s.userKey("")}, {"cookies", s.sessionKey("")}} {
+		keys, values, err := s.getValues(bucket.keyPrefix + "*")
+
+		if err != nil {
+			return count, err
+		}
+
+		for i, value := range values {
+			key := []byte(strings.TrimPrefix(keys[i], bucket.keyPrefix))
+			plaintext, err := openValue(bucket.name, key, value)
+
+			if err != nil {
+				return count, err
+			}
+
+			if masterKey != nil {
+				if plaintext, err = sealValueWithKey(*masterKey, bucket.name, key, plaintext); err != nil {
+					return count, err
+				}
+			}
+
+			// keep the expiry of sessions and don't recreate values that were deleted in the meantime
+			if _, err = s.client.Do("SET", keys[i], string(plaintext), "KEEPTTL", "XX"); err != nil {
+				return count, err
+			}
+
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// View implements Store. Read-only transactions are not isolated from concurrent modifications.
+func (s *redisStore) View(fn func(tx Tx) error) error {
+	tx := &redisTx{store: s, buckets: make(map[string]*redisBucket)}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.err
+}
+
+// Update implements Store. The transaction is retried if a bucket it used was modified concurrently, so fn may be
+// called multiple times.
+func (s *redisStore) Update(fn func(tx Tx) error) error {
+	var err error
+
+	for attempt := 0; attempt < redisTransactionAttempts; attempt++ {
+		var conn *redisConn
+
+		// idle connections may have been closed by the server in the meantime, use a new connection after errors
+		if !isRedisConnectionError(err) {
+			conn = s.client.idleConn()
+		}
+
+		if conn == nil {
+			if conn, err = s.client.dial(); err != nil {
+				return err
+			}
+		}
+
+		var done bool
+
+		if done, err = s.update(conn, fn); done {
+			return err
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("redis: transaction was aborted %d times due to concurrent modifications", redisTransactionAttempts)
+}
+
+// update runs a single attempt of a read-write transaction on the given connection and releases the connection.
+// Returns false if the transaction should be retried, i.e. a bucket it used was modified concurrently or
+// a connection error occurred.
+func (s *redisStore) update(conn *redisConn, fn func(tx Tx) error) (bool, error) {
+	tx := &redisTx{store: s, conn: conn, buckets: make(map[string]*redisBucket)}
+
+	err := fn(tx)
+
+	if isRedisConnectionError(tx.err) {
+		s.client.release(conn, tx.err)
+		return false, tx.err
+	}
+
+	if err == nil {
+		err = tx.err
+	}
+
+	commands := tx.commands()
+
+	if err != nil || len(commands) == 0 {
+		_, unwatchErr := conn.pipeline([][]string{{"UNWATCH"}}, s.client.timeout)
+		s.client.release(conn, unwatchErr)
+
+		return true, err
+	}
+
+	commands = append(append([][]string{{"MULTI"}}, commands...), []string{"EXEC"})
+	replies, err := conn.pipeline(commands, s.client.timeout)
+	s.client.release(conn, err)
+
+	if err != nil {
+		return !isRedisConnectionError(err), err
+	}
+
+	// the reply of EXEC is nil if a watched bucket was modified
+	if replies[len(replies)-1] == nil {
+		return false, nil
+	}
+
+	for _, result := range replies[len(replies)-1].([]interface{}) {
+		if err, ok := result.(redisError); ok {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// do sends the given command using the connection of the transaction (read-write transactions) or any connection
+// (read-only transactions). The first error is kept as error of the transaction.
+func (tx *redisTx) do(args ...string) (interface{}, error) {
+	var reply interface{}
+	var err error
+
+	if tx.conn == nil {
+		reply, err = tx.store.client.Do(args...)
+	} else {
+		var replies []interface{}
+
+		if replies, err = tx.conn.pipeline([][]string{args}, tx.store.client.timeout); err == nil {
+			reply = replies[0]
+		}
+	}
+
+	if err != nil && tx.err == nil {
+		tx.err = err
+	}
+
+	return reply, err
+}
+
+// commands returns the commands that apply the changes of the transaction to the buckets and announce the changed
+// buckets on the revocations channel.
+func (tx *redisTx) commands() [][]string {
+	var names []string
+
+	for name := range tx.buckets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var commands [][]string
+
+	for _, name := range names {
+		bucket := tx.buckets[name]
+
+		if !bucket.cleared && len(bucket.changed) == 0 {
+			continue
+		}
+
+		if bucket.cleared {
+			commands = append(commands, []string{"DEL", bucket.key})
+		}
+
+		set := []string{"HSET", bucket.key}
+		deleted := []string{"HDEL", bucket.key}
+
+		for key := range bucket.changed {
+			if value := bucket.values[key]; value != nil {
+				set = append(set, key, string(value))
+			} else if !bucket.cleared {
+				deleted = append(deleted, key)
+			}
+		}
+
+		if len(set) > 2 {
+			commands = append(commands, set)
+		}
+
+		if len(deleted) > 2 {
+			commands = append(commands, deleted)
+		}
+
+		commands = append(commands, tx.store.publishCommand(redisBucketChangedMessage+name))
+	}
+
+	return commands
+}
+
+// Bucket implements Tx.
+func (tx *redisTx) Bucket(name string) Bucket {
+	if bucket, ok := tx.buckets[name]; ok {
+		return bucket
+	}
+
+	key := tx.store.bucketKey(name)
+
+	if tx.conn == nil {
+		// read-only transactions return nil if the bucket does not exist
+		if reply, err := tx.do("EXISTS", key); err != nil || reply != int64(1) {
+			return nil
+		}
+	} else {
+		// EXEC fails if the bucket is modified after it was watched
+		_, _ = tx.do("WATCH", key)
+	}
+
+	bucket := &redisBucket{
+		tx:      tx,
+		key:     key,
+		values:  make(map[string][]byte),
+		changed: make(map[string]bool),
+	}
+
+	tx.buckets[name] = bucket
+
+	return bucket
+}
+
+// DeleteBucket implements Tx.
+func (tx *redisTx) DeleteBucket(name string) error {
+	if tx.conn == nil {
+		return errors.New("redis: cannot delete a bucket in a read-only transaction")
+	}
+
+	bucket := tx.Bucket(name).(*redisBucket)
+	bucket.values = make(map[string][]byte)
+	bucket.changed = make(map[string]bool)
+	bucket.loaded = true
+	bucket.cleared = true
+
+	return nil
+}
+
+// Get implements Bucket.
+func (b *redisBucket) Get(key []byte) []byte {
+	if value, ok := b.values[string(key)]; ok || b.loaded {
+		return value
+	}
+
+	reply, err := b.tx.do("HGET", b.key, string(key))
+
+	if err != nil {
+		return nil
+	}
+
+	value, _ := reply.([]byte)
+	b.values[string(key)] = value
+
+	return value
+}
+
+// Put implements Bucket.
+func (b *redisBucket) Put(key []byte, value []byte) error {
+	if b.tx.conn == nil {
+		return errors.New("redis: cannot write to a bucket in a read-only transaction")
+	}
+
+	// empty values are stored as empty strings, nil values mark deleted keys
+	b.values[string(key)] = append([]byte{}, value...)
+	b.changed[string(key)] = true
+
+	return nil
+}
+
+// Delete implements Bucket.
+func (b *redisBucket) Delete(key []byte) error {
+	if b.tx.conn == nil {
+		return errors.New("redis: cannot delete from a bucket in a read-only transaction")
+	}
+
+	b.values[string(key)] = nil
+	b.changed[string(key)] = true
+
+	return nil
+}
+
+// ForEach implements Bucket.
+func (b *redisBucket) ForEach(fn func(key []byte, value []byte) error) error {
+	if !b.loaded {
+		reply, err := b.tx.do("HGETALL", b.key)
+
+		if err != nil {
+			return err
+		}
+
+		pairs := redisStrings(reply)
+
+		for i := 0; i+1 < len(pairs); i += 2 {
+			// values written by the transaction take precedence
+			if _, ok := b.values[pairs[i]]; !ok {
+				b.values[pairs[i]] = []byte(pairs[i+1])
+			}
+		}
+
+		b.loaded = true
+	}
+
+	keys := make([]string, 0, len(b.values))
+
+	for key, value := range b.values {
+		if value != nil {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := b.values[key]
+
+		// the entry was deleted by fn
+		if value == nil {
+			continue
+		}
+
+		if err := fn([]byte(key), value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// getValues returns the keys and values of all keys matching the given pattern.
+// Keys that expire in the meantime are skipped.
+func (s *redisStore) getValues(pattern string) ([]string, [][]byte, error) {
+	keys, err := s.client.Scan(pattern)
+
+	if err != nil || len(keys) == 0 {
+		return nil, nil, err
+	}
+
+	commands := make([][]string, len(keys))
+
+	for i, key := range keys {
+		commands[i] = []string{"GET", key}
+	}
+
+	replies, err := s.client.Pipeline(commands...)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var existingKeys []string
+	var values [][]byte
+
+	for i, reply := range replies {
+		if value, ok := reply.([]byte); ok {
+			existingKeys = append(existingKeys, keys[i])
+			values = append(values, value)
+		}
+	}
+
+	return existingKeys, values, nil
+}
+
+// unmarshalSessions unmarshals (and decrypts) the given sessions with the given hashes, sorted by hash like
+// the bbolt implementation.
+func unmarshalSessions(hashes []string, values [][]byte) ([]Cookie, error) {
+	var cookies []Cookie
+
+	for i, value := range values {
+		cookie := Cookie{}
+
+		if err := unmarshalValue("cookies", []byte(hashes[i]), value, &cookie); err != nil {
+			return nil, err
+		}
+
+		cookies = append(cookies, cookie)
+	}
+
+	sort.Slice(cookies, func(i, j int) bool {
+		return cookies[i].Value < cookies[j].Value
+	})
+
+	return cookies, nil
+}
+
+// runRevocationSubscriber drops revoked sessions and changed users from the caches of this server until stop is
+// closed. Returns immediately if the sessions are not stored in Redis.
+// Revocations published while the subscription is lost are missed, so both caches are purged and the signing state is
+// reloaded after every (re)subscription.
+func runRevocationSubscriber(stop <-chan struct{}) {
+	s, ok := store.(*splitStore)
+
+	if !ok || s.redis == nil {
+		return
+	}
+
+	s.redis.client.Subscribe(s.redis.prefix+redisRevocationsChannel, func() {
+		PurgeCookieCache()
+		PurgeBasicAuthCache()
+		invalidateSigningState()
+	}, handleRevocation, stop)
+}
+
+// handleRevocation handles a message published on the revocations channel.
+func handleRevocation(message string) {
+	if hash, ok := strings.CutPrefix(message, redisRevokeSessionMessage); ok {
+		DeleteCookieFromCache(&Cookie{Value: hash})
+	} else if username, ok := strings.CutPrefix(message, redisRevokeUserMessage); ok {
+		DeleteCookiesFromCacheByUsername(username)
+	} else if message == redisPurgeSessionsMessage {
+		PurgeCookieCache()
+	} else if message == redisUsersChangedMessage {
+		PurgeBasicAuthCache()
+	} else if name, ok := strings.CutPrefix(message, redisBucketChangedMessage); ok {
+		if name == "signingKeys" || name == "revokedSessions" {
+			invalidateSigningState()
+		}
+	}
+}
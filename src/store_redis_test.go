@@ -0,0 +1,397 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestRedisStore returns a redisStore connected to the given fakeRedisServer.
+func newTestRedisStore(t *testing.T, server *fakeRedisServer) *redisStore {
+	s := &redisStore{
+		client: newRedisClient(server.Address(), "", 0, time.Second),
+		prefix: "test:",
+	}
+
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	return s
+}
+
+func TestRedisStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return newTestRedisStore(t, newFakeRedisServer(t, ""))
+	})
+}
+
+func TestRedisStoreConcurrentTransactions(t *testing.T) {
+	server := newFakeRedisServer(t, "")
+	s := newTestRedisStore(t, server)
+
+	const transactions = 5
+
+	err := s.Update(func(tx Tx) error {
+		return tx.Bucket("test").Put([]byte("code"), []byte("one-time"))
+	})
+
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+
+	var wait sync.WaitGroup
+	results := make(chan error, 2*transactions)
+
+	for i := 0; i < transactions; i++ {
+		wait.Add(2)
+
+		// every transaction increments the counter
+		go func() {
+			defer wait.Done()
+
+			results <- s.Update(func(tx Tx) error {
+				bucket := tx.Bucket("test")
+				counter, _ := strconv.Atoi(string(bucket.Get([]byte("counter"))))
+
+				return bucket.Put([]byte("counter"), []byte(strconv.Itoa(counter+1)))
+			})
+		}()
+
+		// only a single transaction consumes the code
+		go func() {
+			defer wait.Done()
+
+			results <- s.Update(func(tx Tx) error {
+				bucket := tx.Bucket("test")
+
+				if bucket.Get([]byte("code")) == nil {
+					return os.ErrNotExist
+				}
+
+				return bucket.Delete([]byte("code"))
+			})
+		}()
+	}
+
+	wait.Wait()
+	close(results)
+
+	consumed := 0
+
+	for err := range results {
+		if err == nil {
+			continue
+		} else if errors.Is(err, os.ErrNotExist) {
+			consumed++
+		} else {
+			t.Errorf("Update: %s", err)
+		}
+	}
+
+	if consumed != transactions-1 {
+		t.Errorf("the one-time code was consumed %d times, want once", transactions-consumed)
+	}
+
+	_ = s.View(func(tx Tx) error {
+		if counter := string(tx.Bucket("test").Get([]byte("counter"))); counter != strconv.Itoa(transactions) {
+			t.Errorf("got counter %s, want %d", counter, transactions)
+		}
+
+		return nil
+	})
+}
+
+func TestRedisStoreUsernameIndex(t *testing.T) {
+	server := newFakeRedisServer(t, "")
+	s := newTestRedisStore(t, server)
+
+	expired := Cookie{ID: "1", Value: "hash-expired", Username: "alice", Expires: time.Now().Add(50 * time.Millisecond)}
+	active := Cookie{ID: "2", Value: "hash-active", Username: "alice", Expires: time.Now().Add(time.Hour)}
+
+	for _, cookie := range []Cookie{expired, active} {
+		if err := s.SaveSession(cookie); err != nil {
+			t.Fatalf("SaveSession: %s", err)
+		}
+	}
+
+	reply, err := s.client.Do("SMEMBERS", s.userSessionsKey("alice"))
+
+	if err != nil || len(redisStrings(reply)) != 2 {
+		t.Fatalf("the username index contains %v, %v, want both sessions", reply, err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// the expired session is dropped by Redis, its hash is removed from the index upon the next lookup
+	assertSessions(t, "GetSessionsByUsername", func() ([]Cookie, error) {
+		return s.GetSessionsByUsername("alice")
+	}, "hash-active")
+
+	if reply, err = s.client.Do("SMEMBERS", s.userSessionsKey("alice")); err != nil || len(redisStrings(reply)) != 1 {
+		t.Errorf("the username index contains %v, %v, want the active session only", reply, err)
+	}
+
+	if err = s.DeleteSession("hash-active"); err != nil {
+		t.Fatalf("DeleteSession: %s", err)
+	}
+
+	if reply, err = s.client.Do("SMEMBERS", s.userSessionsKey("alice")); err != nil || len(redisStrings(reply)) != 0 {
+		t.Errorf("the username index contains %v, %v after DeleteSession, want no sessions", reply, err)
+	}
+}
+
+func TestRedisRevocations(t *testing.T) {
+	server := newFakeRedisServer(t, "")
+
+	// this server caches the sessions, the other server revokes them
+	local := newTestRedisStore(t, server)
+	other := newTestRedisStore(t, server)
+
+	previousStore := store
+	store = &splitStore{UserStore: local, SessionStore: local, redis: local}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+		store = previousStore
+		PurgeCookieCache()
+	})
+
+	go func() {
+		runRevocationSubscriber(stop)
+		close(done)
+	}()
+
+	select {
+	case <-server.subscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the revocation subscriber did not subscribe")
+	}
+
+	expires := time.Now().Add(time.Hour)
+	cookies := []Cookie{
+		{ID: "1", Value: "hash-a1", Username: "alice", Expires: expires},
+		{ID: "2", Value: "hash-a2", Username: "alice", Expires: expires},
+		{ID: "3", Value: "hash-b1", Username: "bob", Expires: expires},
+	}
+
+	for i := range cookies {
+		if err := other.SaveSession(cookies[i]); err != nil {
+			t.Fatalf("SaveSession: %s", err)
+		}
+
+		SaveCookieToCache(&cookies[i], "plain-"+cookies[i].Value)
+	}
+
+	if err := other.DeleteSession("hash-a1"); err != nil {
+		t.Fatalf("DeleteSession: %s", err)
+	}
+
+	assertUncached(t, "DeleteSession", "plain-hash-a1")
+
+	if GetCookieFromCache("plain-hash-a2") == nil {
+		t.Fatalf("DeleteSession dropped other sessions from the cache")
+	}
+
+	if err := other.DeleteSessionsByUsername("alice"); err != nil {
+		t.Fatalf("DeleteSessionsByUsername: %s", err)
+	}
+
+	assertUncached(t, "DeleteSessionsByUsername", "plain-hash-a2")
+
+	if err := other.PurgeSessions(); err != nil {
+		t.Fatalf("PurgeSessions: %s", err)
+	}
+
+	assertUncached(t, "PurgeSessions", "plain-hash-b1")
+
+	// other servers reload the signing state once the signing keys or revoked signed sessions were changed
+	signing.mutex.Lock()
+	signing.loaded = time.Now()
+	signing.mutex.Unlock()
+
+	t.Cleanup(resetSigningState)
+
+	err := other.Update(func(tx Tx) error {
+		return tx.Bucket("revokedSessions").Put([]byte("sid:1"), []byte(time.Now().Format(time.RFC3339)))
+	})
+
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		signing.mutex.RLock()
+		loaded := signing.loaded
+		signing.mutex.RUnlock()
+
+		if loaded.IsZero() {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("the signing state was not invalidated after the revoked signed sessions changed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisRevocationsAfterReconnect(t *testing.T) {
+	server := newFakeRedisServer(t, "")
+	local := newTestRedisStore(t, server)
+
+	previousStore := store
+	store = &splitStore{UserStore: local, SessionStore: local, redis: local}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+		store = previousStore
+		PurgeCookieCache()
+		PurgeBasicAuthCache()
+	})
+
+	go func() {
+		runRevocationSubscriber(stop)
+		close(done)
+	}()
+
+	waitForSubscription := func() {
+		t.Helper()
+
+		select {
+		case <-server.subscribed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the revocation subscriber did not subscribe")
+		}
+	}
+
+	waitForSubscription()
+
+	// the caches are purged upon the first subscription as well, wait until the subscriber handles messages
+	synced := Cookie{ID: "0", Value: "hash-synced", Username: "bob", Expires: time.Now().Add(time.Hour)}
+	SaveCookieToCache(&synced, "plain-hash-synced")
+
+	if err := local.DeleteSession(synced.Value); err != nil {
+		t.Fatalf("DeleteSession: %s", err)
+	}
+
+	assertUncached(t, "DeleteSession", "plain-hash-synced")
+
+	// revocations published while the subscription is lost are missed, so the caches have to be purged
+	cookie := Cookie{ID: "1", Value: "hash-a1", Username: "alice", Expires: time.Now().Add(time.Hour)}
+	SaveCookieToCache(&cookie, "plain-hash-a1")
+	SaveBasicAuthToCache("alice", "secret", &Identity{Username: "alice"})
+
+	server.dropSubscriptions()
+	waitForSubscription()
+
+	assertUncached(t, "reconnect", "plain-hash-a1")
+
+	if GetBasicAuthFromCache("alice", "secret") != nil {
+		t.Errorf("reconnect: the HTTP Basic authentication cache was not purged")
+	}
+}
+
+// assertUncached waits until the session with the given plaintext cookie value was dropped from the session cache.
+func assertUncached(t *testing.T, name string, plainCookieValue string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for GetCookieFromCache(plainCookieValue) != nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: the revoked session is still cached", name)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisStoreEncryption(t *testing.T) {
+	masterKeysOnce.Do(func() {})
+	previousKeys := masterKeys
+
+	key, err := GenerateMasterKey()
+
+	if err != nil {
+		t.Fatalf("GenerateMasterKey: %s", err)
+	}
+
+	if masterKeys, err = parseMasterKeys(key); err != nil {
+		t.Fatalf("parseMasterKeys: %s", err)
+	}
+
+	t.Cleanup(func() {
+		masterKeys = previousKeys
+	})
+
+	server := newFakeRedisServer(t, "")
+	s := newTestRedisStore(t, server)
+
+	if err = s.SaveUser(User{Username: "alice", Password: "secret-hash"}); err != nil {
+		t.Fatalf("SaveUser: %s", err)
+	}
+
+	cookie := Cookie{ID: "1", Value: "hash-a1", Username: "alice", ClientIP: "192.0.2.1", Expires: time.Now().Add(time.Hour)}
+
+	if err = s.SaveSession(cookie); err != nil {
+		t.Fatalf("SaveSession: %s", err)
+	}
+
+	assertRawValues := func(name string, encrypted bool) {
+		t.Helper()
+
+		for key, plaintext := range map[string]string{s.userKey("alice"): "secret-hash", s.sessionKey("hash-a1"): "192.0.2.1"} {
+			reply, err := s.client.Do("GET", key)
+			value := redisString(reply)
+
+			if err != nil || strings.HasPrefix(value, encryptedValuePrefix) != encrypted || strings.Contains(value, plaintext) == encrypted {
+				t.Errorf("%s: unexpected value of key '%s': %q, %v", name, key, value, err)
+			}
+		}
+
+		if user, err := s.GetUser("alice"); err != nil || user == nil || user.Password != "secret-hash" {
+			t.Errorf("%s: GetUser returned %+v, %v", name, user, err)
+		}
+
+		assertSessions(t, name, s.GetSessions, "hash-a1")
+		assertSessions(t, name, func() ([]Cookie, error) {
+			return s.GetSessionsByUsername("alice")
+		}, "hash-a1")
+	}
+
+	assertRawValues("SaveUser/SaveSession", true)
+
+	if count, err := s.Rekey(nil); err != nil || count != 2 {
+		t.Fatalf("Rekey (decrypt): got %d, %v, want 2 values", count, err)
+	}
+
+	assertRawValues("Rekey (decrypt)", false)
+
+	if count, err := s.Rekey(&masterKeys[0]); err != nil || count != 2 {
+		t.Fatalf("Rekey: got %d, %v, want 2 values", count, err)
+	}
+
+	assertRawValues("Rekey", true)
+
+	server.mutex.Lock()
+	_, hasExpiry := server.expires[s.sessionKey("hash-a1")]
+	server.mutex.Unlock()
+
+	if !hasExpiry {
+		t.Errorf("Rekey removed the expiry of the session")
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMain uses the default configuration instead of parsing the config.ini next to the test binary.
+func TestMain(m *testing.M) {
+	parsed = true
+
+	os.Exit(m.Run())
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+}
+
+func TestBoltStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		databaseFilePath = filepath.Join(t.TempDir(), "nginx-auth-server.db")
+
+		s, err := openBoltStore()
+
+		if err != nil {
+			t.Fatalf("could not open database: %s", err)
+		}
+
+		t.Cleanup(func() {
+			_ = s.Close()
+		})
+
+		return s
+	})
+}
+
+// testStore runs the tests of the Store contract against the stores returned by newStore.
+// Every test gets a new, empty store.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("UserStore", func(t *testing.T) {
+		testUserStore(t, newStore(t))
+	})
+
+	t.Run("SessionStore", func(t *testing.T) {
+		testSessionStore(t, newStore(t))
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		testTransactions(t, newStore(t))
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		testImport(t, newStore(t))
+	})
+}
+
+// testUserStore tests the UserStore contract.
+func testUserStore(t *testing.T, s UserStore) {
+	if user, err := s.GetUser("alice"); err != nil || user != nil {
+		t.Fatalf("GetUser of a missing user: got %v, %v, want nil, nil", user, err)
+	}
+
+	for _, username := range []string{"bob", "alice"} {
+		if err := s.SaveUser(User{Username: username, Password: "hash-" + username, Groups: []string{"users"}}); err != nil {
+			t.Fatalf("SaveUser: %s", err)
+		}
+	}
+
+	user, err := s.GetUser("alice")
+
+	if err != nil || user == nil {
+		t.Fatalf("GetUser: got %v, %v", user, err)
+	}
+
+	if user.Password != "hash-alice" || len(user.Groups) != 1 || user.Groups[0] != "users" {
+		t.Errorf("GetUser returned %+v", user)
+	}
+
+	users, err := s.GetUsers()
+
+	if err != nil || len(users) != 2 || users[0].Username != "alice" || users[1].Username != "bob" {
+		t.Fatalf("GetUsers: got %+v, %v, want alice and bob", users, err)
+	}
+
+	if err = s.DeleteUser("alice"); err != nil {
+		t.Fatalf("DeleteUser: %s", err)
+	}
+
+	if user, err = s.GetUser("alice"); err != nil || user != nil {
+		t.Errorf("GetUser of a deleted user: got %v, %v, want nil, nil", user, err)
+	}
+
+	if users, err = s.GetUsers(); err != nil || len(users) != 1 {
+		t.Errorf("GetUsers after DeleteUser: got %+v, %v, want bob", users, err)
+	}
+}
+
+// testSessionStore tests the SessionStore contract.
+func testSessionStore(t *testing.T, s SessionStore) {
+	expires := time.Now().Add(time.Hour)
+
+	if cookie, err := s.GetSession("missing"); err != nil || cookie != nil {
+		t.Fatalf("GetSession of a missing session: got %v, %v, want nil, nil", cookie, err)
+	}
+
+	sessions := []Cookie{
+		{ID: "1", Value: "hash-a1", Username: "alice", Expires: expires},
+		{ID: "2", Value: "hash-a2", Username: "alice", Expires: expires},
+		{ID: "3", Value: "hash-b1", Username: "bob", Expires: expires},
+		{ID: "4", Value: "hash-c1", Username: "carol", Expires: expires},
+	}
+
+	for _, cookie := range sessions {
+		if err := s.SaveSession(cookie); err != nil {
+			t.Fatalf("SaveSession: %s", err)
+		}
+	}
+
+	cookie, err := s.GetSession("hash-a2")
+
+	if err != nil || cookie == nil || cookie.ID != "2" || cookie.Username != "alice" || !cookie.Expires.Equal(expires) {
+		t.Fatalf("GetSession: got %+v, %v", cookie, err)
+	}
+
+	assertSessions(t, "GetSessions", s.GetSessions, "hash-a1", "hash-a2", "hash-b1", "hash-c1")
+	assertSessions(t, "GetSessionsByUsername", func() ([]Cookie, error) {
+		return s.GetSessionsByUsername("alice")
+	}, "hash-a1", "hash-a2")
+
+	if err = s.DeleteSession("hash-a1"); err != nil {
+		t.Fatalf("DeleteSession: %s", err)
+	}
+
+	if cookie, err = s.GetSession("hash-a1"); err != nil || cookie != nil {
+		t.Errorf("GetSession of a deleted session: got %v, %v, want nil, nil", cookie, err)
+	}
+
+	assertSessions(t, "GetSessionsByUsername after DeleteSession", func() ([]Cookie, error) {
+		return s.GetSessionsByUsername("alice")
+	}, "hash-a2")
+
+	if err = s.DeleteSessionsByUsername("alice"); err != nil {
+		t.Fatalf("DeleteSessionsByUsername: %s", err)
+	}
+
+	assertSessions(t, "GetSessionsByUsername after DeleteSessionsByUsername", func() ([]Cookie, error) {
+		return s.GetSessionsByUsername("alice")
+	})
+
+	deleted, err := s.DeleteSessionsWhere(func(cookie *Cookie) bool {
+		return cookie.Username == "bob"
+	})
+
+	if err != nil || len(deleted) != 1 || deleted[0].Value != "hash-b1" {
+		t.Fatalf("DeleteSessionsWhere: got %+v, %v, want the session of bob", deleted, err)
+	}
+
+	assertSessions(t, "GetSessions after DeleteSessionsWhere", s.GetSessions, "hash-c1")
+
+	if err = s.PurgeSessions(); err != nil {
+		t.Fatalf("PurgeSessions: %s", err)
+	}
+
+	assertSessions(t, "GetSessions after PurgeSessions", s.GetSessions)
+	assertSessions(t, "GetSessionsByUsername after PurgeSessions", func() ([]Cookie, error) {
+		return s.GetSessionsByUsername("carol")
+	})
+}
+
+// testTransactions tests the generic buckets of the Store contract.
+func testTransactions(t *testing.T, s Store) {
+	err := s.View(func(tx Tx) error {
+		if tx.Bucket("test") != nil {
+			t.Errorf("read-only transaction returned a bucket that does not exist")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+
+	err = s.Update(func(tx Tx) error {
+		bucket := tx.Bucket("test")
+
+		for _, key := range []string{"b", "a", "c"} {
+			if err := bucket.Put([]byte(key), []byte("value-"+key)); err != nil {
+				return err
+			}
+		}
+
+		return bucket.Delete([]byte("c"))
+	})
+
+	if err != nil {
+		t.Fatalf("Update: %s", err)
+	}
+
+	// a failed transaction must not modify the buckets
+	_ = s.Update(func(tx Tx) error {
+		_ = tx.Bucket("test").Put([]byte("d"), []byte("value-d"))
+		return os.ErrInvalid
+	})
+
+	var keys []string
+
+	err = s.View(func(tx Tx) error {
+		bucket := tx.Bucket("test")
+
+		if value := string(bucket.Get([]byte("a"))); value != "value-a" {
+			t.Errorf("Get: got '%s', want 'value-a'", value)
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+	})
+
+	if err != nil {
+		t.Fatalf("View: %s", err)
+	}
+
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("ForEach: got keys %v, want [a b]", keys)
+	}
+
+	if err = s.Update(func(tx Tx) error { return tx.DeleteBucket("test") }); err != nil {
+		t.Fatalf("DeleteBucket: %s", err)
+	}
+
+	_ = s.View(func(tx Tx) error {
+		if tx.Bucket("test") != nil {
+			t.Errorf("bucket still exists after DeleteBucket")
+		}
+
+		return nil
+	})
+}
+
+// assertSessions asserts that get returns the sessions with the given hashes in the given order.
+func assertSessions(t *testing.T, name string, get func() ([]Cookie, error), hashes ...string) {
+	t.Helper()
+
+	cookies, err := get()
+
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+
+	if len(cookies) != len(hashes) {
+		t.Fatalf("%s: got %d sessions, want %d", name, len(cookies), len(hashes))
+	}
+
+	for i := range cookies {
+		if cookies[i].Value != hashes[i] {
+			t.Errorf("%s: got session '%s' at index %d, want '%s'", name, cookies[i].Value, i, hashes[i])
+		}
+	}
+}
+
+// testImport tests Store.Import.
+func testImport(t *testing.T, s Store) {
+	expires := time.Now().Add(time.Hour)
+
+	if err := s.SaveUser(User{Username: "alice", Password: "hash-alice"}); err != nil {
+		t.Fatalf("SaveUser: %s", err)
+	}
+
+	if err := s.SaveSession(Cookie{Value: "hash-a1", Username: "alice", Expires: expires}); err != nil {
+		t.Fatalf("SaveSession: %s", err)
+	}
+
+	users := []User{{Username: "bob", Password: "hash-bob"}}
+	sessions := []Cookie{{Value: "hash-b1", Username: "bob", Expires: expires}}
+
+	if err := s.Import(users, sessions, false); err != nil {
+		t.Fatalf("Import: %s", err)
+	}
+
+	if users, err := s.GetUsers(); err != nil || len(users) != 2 {
+		t.Fatalf("GetUsers after Import: got %v, %v, want alice and bob", users, err)
+	}
+
+	if cookies, err := s.GetSessionsByUsername("bob"); err != nil || len(cookies) != 1 || cookies[0].Value != "hash-b1" {
+		t.Fatalf("GetSessionsByUsername after Import: got %v, %v", cookies, err)
+	}
+
+	if err := s.Import(users, sessions, true); err != nil {
+		t.Fatalf("Import with replace: %s", err)
+	}
+
+	if user, err := s.GetUser("alice"); err != nil || user != nil {
+		t.Errorf("GetUser of a replaced user: got %v, %v, want nil, nil", user, err)
+	}
+
+	if cookies, err := s.GetSessions(); err != nil || len(cookies) != 1 || cookies[0].Value != "hash-b1" {
+		t.Errorf("GetSessions after Import with replace: got %v, %v, want hash-b1", cookies, err)
+	}
+
+	if cookies, err := s.GetSessionsByUsername("alice"); err != nil || len(cookies) != 0 {
+		t.Errorf("GetSessionsByUsername of a replaced session: got %v, %v, want none", cookies, err)
+	}
+}
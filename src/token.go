@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// This file handles personal API tokens. API tokens are long-lived, revocable credentials for non-browser clients
+// (scripts, CI jobs, monitoring probes) that are sent using the 'Authorization: Bearer <token>' header.
+// The plaintext token has the syntax 'nas_<id>_<secret>' and is only shown once upon creation.
+
+const apiTokenPrefix = "nas_"
+
+// APIToken is the structure for the database representation of a personal API token
+type APIToken struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	Hash        string    `json:"hash"` // Hash :: SHA-256 hash of the token secret
+	Description string    `json:"description"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"` // Expires :: zero value if the token does not expire
+}
+
+// IsExpired returns true if the token has an expiry date in the past.
+func (token *APIToken) IsExpired() bool {
+	return !token.Expires.IsZero() && token.Expires.Before(time.Now())
+}
+
+// CreateAPIToken creates a new API token for the given username and saves it to the database.
+// If lifetime is zero, the token does not expire. Returns the plaintext token and the saved APIToken.
+func CreateAPIToken(username string, description string, lifetime time.Duration) (string, *APIToken, error) {
+	if user, err := store.GetUser(username); err != nil {
+		return "", nil, err
+	} else if user == nil {
+		return "", nil, errors.New("user with username '" + username + "' does not exist")
+	}
+
+	id, err := GenerateRandomBytes(8)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	secret, err := GenerateRandomBytes(32)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := APIToken{
+		ID:          hex.EncodeToString(id),
+		Username:    username,
+		Hash:        HashSHA256(hex.EncodeToString(secret)),
+		Description: description,
+		Created:     time.Now(),
+	}
+
+	if lifetime > 0 {
+		token.Expires = token.Created.Add(lifetime)
+	}
+
+	err = store.Update(func(tx Tx) error {
+		bucket := tx.Bucket("tokens")
+
+		buffer, err := json.Marshal(token)
+
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(token.ID), buffer)
+	})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return fmt.Sprintf("%s%s_%s", apiTokenPrefix, token.ID, hex.EncodeToString(secret)), &token, nil
+}
+
+// GetAPITokens returns all API tokens in the database.
+func GetAPITokens() ([]APIToken, error) {
+	var tokens []APIToken
+
+	err := store.View(func(tx Tx) error {
+		bucket := tx.Bucket("tokens")
+
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(key, value []byte) error {
+			token := APIToken{}
+
+			if err := json.Unmarshal(value, &token); err != nil {
+				return err
+			}
+
+			tokens = append(tokens, token)
+
+			return nil
+		})
+	})
+
+	return tokens, err
+}
+
+// GetAPITokensByUsername returns all API tokens of the user with the given username.
+func GetAPITokensByUsername(username string) ([]APIToken, error) {
+	allTokens, err := GetAPITokens()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []APIToken
+
+	for _, token := range allTokens {
+		if token.Username == username {
+			tokens = append(tokens, token)
+		}
+	}
+
+	return tokens, nil
+}
+
+// GetAPITokenByID looks up the API token with the given ID in the database.
+// Returns nil and no error if the token was not found.
+func GetAPITokenByID(id string) (*APIToken, error) {
+	var token *APIToken
+
+	err := store.View(func(tx Tx) error {
+		bucket := tx.Bucket("tokens")
+
+		if bucket == nil {
+			return nil
+		}
+
+		v := bucket.Get([]byte(id))
+
+		if v == nil {
+			return nil
+		}
+
+		return json.Unmarshal(v, &token)
+	})
+
+	return token, err
+}
+
+// DeleteAPIToken deletes the API token with the given ID from the database.
+func DeleteAPIToken(id string) error {
+	token, err := GetAPITokenByID(id)
+
+	if err != nil {
+		return err
+	}
+
+	if token == nil {
+		return errors.New("token with ID '" + id + "' does not exist")
+	}
+
+	return store.Update(func(tx Tx) error {
+		bucket := tx.Bucket("tokens")
+
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Delete([]byte(id))
+	})
+}
+
+// DeleteAPITokensByUsername deletes all API tokens of the user with the given username.
+func DeleteAPITokensByUsername(username string) error {
+	return store.Update(func(tx Tx) error {
+		bucket := tx.Bucket("tokens")
+
+		if bucket == nil {
+			return nil
+		}
+
+		var ids [][]byte
+
+		err := bucket.ForEach(func(key, value []byte) error {
+			token := APIToken{}
+
+			if err := json.Unmarshal(value, &token); err != nil {
+				return err
+			}
+
+			if token.Username == username {
+				ids = append(ids, key)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if err := bucket.Delete(id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// VerifyAPIToken returns the APIToken and nil if the given plaintext token is valid.
+// Returns nil and an error if the token was not found, does not match or is expired.
+func VerifyAPIToken(plainToken string) (*APIToken, error) {
+	id, secret, err := DecodeAPIToken(plainToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := GetAPITokenByID(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token == nil {
+		return nil, errors.New("error: token not found")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(HashSHA256(secret))) != 1 {
+		return nil, errors.New("error: token does not match")
+	}
+
+	if token.IsExpired() {
+		return nil, errors.New("error: token is expired")
+	}
+
+	return token, nil
+}
+
+// DecodeAPIToken decodes the given plaintext token and returns the token ID and the secret.
+// Returns an error if the given token did not match the expected syntax.
+// Example for token param: 'nas_3f2a......9c1e_8b0d......77af'.
+func DecodeAPIToken(plainToken string) (id string, secret string, err error) {
+	parts := strings.Split(strings.TrimPrefix(plainToken, apiTokenPrefix), "_")
+
+	if !strings.HasPrefix(plainToken, apiTokenPrefix) || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.New("API token does not match syntax")
+	}
+
+	return parts[0], parts[1], nil
+}
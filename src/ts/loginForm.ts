@@ -128,7 +128,25 @@ export default class LoginForm {
         // process API response to determine error origin
         const responseText = await response.text();
 
-        if (responseText.includes('TOTP')) {
+        if (responseText.includes('session limit')) {
+          this.usernameInput.setCustomValidity('Session limit reached. Sign out on another device first.');
+          this.submitButton.disabled = true;
+
+          // clear error message after value change on username input
+          this.usernameInput.addEventListener('input', () => {
+            this.usernameInput.setCustomValidity('');
+            this.submitButton.removeAttribute('disabled');
+          }, { once: true });
+        } else if (responseText.includes('account disabled')) {
+          this.usernameInput.setCustomValidity('This account is disabled. Contact your administrator.');
+          this.submitButton.disabled = true;
+
+          // clear error message after value change on username input
+          this.usernameInput.addEventListener('input', () => {
+            this.usernameInput.setCustomValidity('');
+            this.submitButton.removeAttribute('disabled');
+          }, { once: true });
+        } else if (responseText.includes('TOTP')) {
           this.usernameInput.disabled = true;
           this.passwordInput.disabled = true;
 
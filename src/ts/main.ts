@@ -1,4 +1,5 @@
 import LoginForm from './loginForm';
+import PasswordForm from './passwordForm';
 import Recaptcha from './recaptcha';
 import SessionNotice from './sessionNotice';
 import PasswordInput from './passwordInput';
@@ -21,4 +22,11 @@ if (loginForm) {
   LoginForm.init(loginForm);
 }
 
+const passwordForm = <HTMLFormElement>document.querySelector('form.password-form');
+
+// Initialize password change logic if the password form is present
+if (passwordForm) {
+  PasswordForm.init(passwordForm);
+}
+
 PasswordInput.init();
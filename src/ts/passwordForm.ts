@@ -0,0 +1,159 @@
+import SessionNotice from './sessionNotice';
+
+/**
+ * This class handles all password form related logic, including dynamic input validation.
+ */
+export default class PasswordForm {
+  /** password <form> element */
+  form: HTMLFormElement;
+
+  /** current password <input> element */
+  currentPasswordInput: HTMLInputElement;
+
+  /** new password <input> element */
+  newPasswordInput: HTMLInputElement;
+
+  /** repeated new password <input> element */
+  repeatPasswordInput: HTMLInputElement;
+
+  /** TOTP <input> element, only present if TOTP is enabled for the user */
+  totpInput: HTMLInputElement | null;
+
+  /** 'sign out all other sessions' <input> element */
+  signOutInput: HTMLInputElement;
+
+  /** success notice */
+  notice: HTMLElement;
+
+  /** submit <button> element */
+  submitButton: HTMLButtonElement;
+
+  private constructor(form: HTMLFormElement) {
+    // retrieve mandatory HTMLElements from current form and assign
+    this.form = form;
+    this.currentPasswordInput = form.querySelector('#inputCurrentPassword');
+    this.newPasswordInput = form.querySelector('#inputNewPassword');
+    this.repeatPasswordInput = form.querySelector('#inputRepeatPassword');
+    this.totpInput = form.querySelector('#inputTotp');
+    this.signOutInput = form.querySelector('#inputSignOutOtherSessions');
+    this.notice = form.querySelector('#passwordChangedNotice');
+    this.submitButton = form.querySelector('button[type="submit"]');
+
+    if (!this.currentPasswordInput || !this.newPasswordInput || !this.repeatPasswordInput
+      || !this.signOutInput || !this.notice || !this.submitButton) {
+      throw new Error('error: password inputs, sign out input, notice or submit button is missing');
+    }
+
+    // clear the mismatch error after value changes on either new password input
+    [this.newPasswordInput, this.repeatPasswordInput].forEach((input) => {
+      input.addEventListener('input', () => this.repeatPasswordInput.setCustomValidity(''));
+    });
+
+    // attach validation logic upon form submission
+    form.addEventListener('submit', (event) => this.onFormSubmit(event));
+  }
+
+  /** Initialize the given <form> as a password form */
+  static init(form: HTMLFormElement): PasswordForm {
+    return new PasswordForm(form);
+  }
+
+  /**
+   * Dynamically validates the form using Bootstrap form validation.
+   * After successful client side validation, the form content will be submitted to the API.
+   * @param event - event that has been triggered by the user
+   * @returns void
+   */
+  async onFormSubmit(event: Event) {
+    event.preventDefault();
+
+    this.notice.classList.add('d-none');
+
+    if (this.newPasswordInput.value !== this.repeatPasswordInput.value) {
+      this.repeatPasswordInput.setCustomValidity('Passwords do not match.');
+    }
+
+    // validate form before sending POST request
+    this.form.classList.add('was-validated');
+
+    if (!this.form.checkValidity()) {
+      return;
+    }
+
+    // replace button text with a spinner while the request is ongoing
+    const originalButtonHTML = this.submitButton.innerHTML;
+    const buttonWidth = this.submitButton.getBoundingClientRect().width;
+
+    this.submitButton.setAttribute('style', `width: ${buttonWidth}px;`);
+    this.submitButton.innerHTML = '<i class="fa-solid fa-circle-notch fa-spin"></i>';
+    this.submitButton.disabled = true;
+
+    try {
+      const response = await fetch(this.form.action, {
+        method: 'post',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({
+          inputCurrentPassword: this.currentPasswordInput.value,
+          inputNewPassword: this.newPasswordInput.value,
+          inputTotp: this.totpInput ? this.totpInput.value : '',
+          signOutOtherSessions: this.signOutInput.checked,
+        }),
+      });
+
+      this.resetSubmitButton(originalButtonHTML);
+
+      if (response.ok) {
+        // the session is replaced if the other sessions were signed out
+        const json = await response.json();
+
+        localStorage.setItem(SessionNotice.TOKEN_EXPIRATION_LOCALSTORAGE_KEY, String(json.expires));
+
+        this.form.reset();
+        this.form.classList.remove('was-validated');
+        this.notice.classList.remove('d-none');
+
+        return;
+      }
+
+      // process API response to determine error origin
+      const responseText = await response.text();
+
+      if (responseText.includes('TOTP') && this.totpInput) {
+        this.showError(this.totpInput, 'Invalid TOTP.');
+      } else if (responseText.includes('invalid password')) {
+        this.showError(this.currentPasswordInput, 'Invalid password.');
+      } else if (response.status === 400) {
+        this.showError(this.newPasswordInput, 'Password does not meet the requirements.');
+      } else if (response.status === 401) {
+        // the session expired in the meantime
+        window.location.reload();
+      } else {
+        this.showError(this.currentPasswordInput, 'Password could not be changed.');
+      }
+    } catch (error) {
+      this.resetSubmitButton(originalButtonHTML);
+      console.error(error);
+    }
+  }
+
+  /**
+   * Shows the given error message on the given input until its value changes.
+   * @param input - <input>-Element the error belongs to
+   * @param message - error message
+   */
+  showError(input: HTMLInputElement, message: string): void {
+    input.setCustomValidity(message);
+    input.reportValidity();
+
+    input.addEventListener('input', () => input.setCustomValidity(''), { once: true });
+  }
+
+  /** Resets the submit button to the initial state. */
+  resetSubmitButton(originalHtml: string): void {
+    this.submitButton.innerHTML = originalHtml;
+    this.submitButton.removeAttribute('style');
+    this.submitButton.disabled = false;
+  }
+}
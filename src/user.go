@@ -1,119 +1,286 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
-	bolt "go.etcd.io/bbolt"
+	"net/mail"
+	"strings"
+	"time"
 )
 
 // User is the structure for the database representation of a user
 type User struct {
-	Username  string `json:"username"`
-	Password  string `json:"password"`  // Password :: argon2id hash
-	OtpSecret []byte `json:"otpSecret"` // OtpSecret :: encrypted OTP secret key
+	Username    string    `json:"username"`
+	Password    string    `json:"password"`  // Password :: argon2id hash
+	OtpSecret   []byte    `json:"otpSecret"` // OtpSecret :: encrypted OTP secret key
+	Groups      []string  `json:"groups"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"displayName"`
+	Created     time.Time `json:"created"`     // Created :: zero for users created before the creation time was recorded
+	LastLogin   time.Time `json:"lastLogin"`   // LastLogin :: time of the last successful login, zero if never logged in
+	LastLoginIP string    `json:"lastLoginIp"` // LastLoginIP :: client IP address of the last successful login
+	Disabled    bool      `json:"disabled"`    // Disabled :: disabled users cannot log in, their sessions are revoked
+	DisabledAt  time.Time `json:"disabledAt"`
+	// DisabledReason :: optional note of the administrator why the user was disabled
+	DisabledReason string `json:"disabledReason"`
 }
 
-// GetUsers returns all users in the database.
-func GetUsers() []User {
-	db := initDatabase()
-	defer db.Close()
+// CreateUser adds the given User to the database.
+func CreateUser(user *User) error {
+	if existingUser, err := store.GetUser(user.Username); err != nil {
+		return err
+	} else if existingUser != nil {
+		return errors.New("user with username '" + user.Username + "' already exists")
+	}
 
-	var users []User
+	return store.SaveUser(*user)
+}
 
-	_ = db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("users"))
+// RemoveUser finds the user corresponding to the given username and removes the user from the database.
+func RemoveUser(username string) error {
+	if user, err := store.GetUser(username); err != nil {
+		return err
+	} else if user == nil {
+		return errors.New("user with username '" + username + "' does not exist")
+	}
 
-		if bucket == nil {
-			return nil
-		}
+	return store.DeleteUser(username)
+}
 
-		_ = bucket.ForEach(func(key, value []byte) error {
-			user := User{}
-			_ = json.Unmarshal(value, &user)
-			users = append(users, user)
+// UpdateUserProfile sets the email address and the display name of the user with the given username.
+// Nil values are not changed.
+func UpdateUserProfile(username string, email *string, displayName *string) error {
+	user, err := store.GetUser(username)
 
-			return nil
-		})
+	if err != nil {
+		return err
+	} else if user == nil {
+		return errors.New("user with username '" + username + "' does not exist")
+	}
 
-		return nil
-	})
+	if email != nil {
+		if err = CheckEmail(*email); err != nil {
+			return err
+		}
+
+		user.Email = *email
+	}
 
-	return users
+	if displayName != nil {
+		user.DisplayName = *displayName
+	}
+
+	return store.SaveUser(*user)
 }
 
-// CreateUser adds the given User to the database.
-func CreateUser(user *User) error {
-	if GetUserByUsername(user.Username) != nil {
-		return errors.New("user with username '" + user.Username + "' already exists")
+// UpdateUserGroups adds the user with the given username to the groups in add and removes the user from the groups
+// in remove (case-insensitive). Sessions capture the groups at the time of login, so all sessions of the user are
+// revoked if the groups changed. Returns true if the groups changed.
+func UpdateUserGroups(username string, add []string, remove []string) (bool, error) {
+	user, err := store.GetUser(username)
+
+	if err != nil {
+		return false, err
+	} else if user == nil {
+		return false, errors.New("user with username '" + username + "' does not exist")
 	}
 
-	db := initDatabase()
-	defer db.Close()
+	var groups []string
 
-	return db.Update(func(tx *bolt.Tx) error {
-		_, _ = tx.CreateBucketIfNotExists([]byte("users"))
-		bucket := tx.Bucket([]byte("users"))
+	for _, group := range user.Groups {
+		if !containsFold(remove, group) {
+			groups = append(groups, group)
+		}
+	}
 
-		buffer, err := json.Marshal(user)
-		if err != nil {
-			return err
+	for _, group := range add {
+		if group = strings.TrimSpace(group); group != "" && !containsFold(groups, group) {
+			groups = append(groups, group)
 		}
+	}
+
+	if len(groups) == len(user.Groups) && containsAllFold(groups, user.Groups) {
+		return false, nil
+	}
+
+	user.Groups = groups
 
-		// Persist bytes to users bucket.
-		return bucket.Put([]byte(user.Username), buffer)
-	})
+	if err = store.SaveUser(*user); err != nil {
+		return false, err
+	}
+
+	return true, RevokeUserSessions(username)
 }
 
-// RemoveUser finds the user corresponding to the given username and removes the user from the database.
-func RemoveUser(username string) error {
-	if GetUserByUsername(username) == nil {
+// containsAllFold returns true if the given list contains all given values (case-insensitive).
+func containsAllFold(list []string, values []string) bool {
+	for _, value := range values {
+		if !containsFold(list, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DisableUser disables the user with the given username and revokes all sessions of the user. Disabled users keep
+// their password and TOTP enrollment, but cannot log in until they are enabled again.
+func DisableUser(username string, reason string) error {
+	user, err := store.GetUser(username)
+
+	if err != nil {
+		return err
+	} else if user == nil {
 		return errors.New("user with username '" + username + "' does not exist")
 	}
 
-	db := initDatabase()
-	defer db.Close()
+	user.Disabled = true
+	user.DisabledAt = time.Now()
+	user.DisabledReason = reason
 
-	return db.Update(func(tx *bolt.Tx) error {
-		_, _ = tx.CreateBucketIfNotExists([]byte("users"))
-		bucket := tx.Bucket([]byte("users"))
+	if err = store.SaveUser(*user); err != nil {
+		return err
+	}
 
-		return bucket.Delete([]byte(username))
-	})
+	return RevokeUserSessions(username)
 }
 
-// GetUserByUsername looks up username in the database and returns the User if found.
-// Returns nil if the user was not found.
-func GetUserByUsername(username string) *User {
-	db := initDatabase()
-	defer db.Close()
+// RevokeUserSessions deletes all sessions and revokes all signed sessions of the user with the given username.
+func RevokeUserSessions(username string) error {
+	if err := DeleteCookiesByUsername(username); err != nil {
+		return err
+	}
 
-	var user *User
+	return RevokeSignedSessionsByUsername(username)
+}
 
-	_ = db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("users"))
+// ChangeUserPassword changes the password of the user with the given username after verifying the current password.
+// The TOTP secret is re-encrypted with the new password, so the TOTP enrollment of the user is kept.
+func ChangeUserPassword(username string, currentPassword string, newPassword string) error {
+	user, err := store.GetUser(username)
 
-		if bucket == nil {
-			return nil
-		}
+	if err != nil {
+		return err
+	} else if user == nil {
+		return errors.New("user with username '" + username + "' does not exist")
+	}
 
-		v := bucket.Get([]byte(username))
+	if CompareHashAndPassword(user.Password, currentPassword) != nil {
+		return errInvalidPassword
+	}
 
-		if v == nil {
-			return nil
-		}
+	if err = CheckPasswordRequirements(newPassword); err != nil {
+		return err
+	}
+
+	// the TOTP secret is encrypted with the password of the user
+	if len(user.OtpSecret) != 0 {
+		user.OtpSecret = Encrypt(Decrypt(user.OtpSecret, currentPassword), newPassword)
+	}
 
-		_ = json.Unmarshal(v, &user)
+	user.Password = GenerateHash(newPassword)
 
+	if err = store.SaveUser(*user); err != nil {
+		return err
+	}
+
+	// the old password must not be accepted from the cache of the HTTP Basic authentication
+	PurgeBasicAuthCache()
+
+	return nil
+}
+
+// ResetUserPassword sets the password of the user with the given username without knowing the current password.
+// Since the TOTP secret cannot be decrypted without the current password, it is replaced with the given encrypted
+// TOTP secret, which is nil to disable TOTP until the user is enrolled again.
+// Returns true if an existing TOTP enrollment of the user was removed or replaced.
+func ResetUserPassword(username string, newPassword string, otpSecret []byte) (bool, error) {
+	user, err := store.GetUser(username)
+
+	if err != nil {
+		return false, err
+	} else if user == nil {
+		return false, errors.New("user with username '" + username + "' does not exist")
+	}
+
+	if err = CheckPasswordRequirements(newPassword); err != nil {
+		return false, err
+	}
+
+	otpReset := len(user.OtpSecret) != 0
+
+	user.Password = GenerateHash(newPassword)
+	user.OtpSecret = otpSecret
+
+	return otpReset, store.SaveUser(*user)
+}
+
+// EnableUser enables the previously disabled user with the given username.
+func EnableUser(username string) error {
+	user, err := store.GetUser(username)
+
+	if err != nil {
+		return err
+	} else if user == nil {
+		return errors.New("user with username '" + username + "' does not exist")
+	} else if !user.Disabled {
+		return errors.New("user with username '" + username + "' is not disabled")
+	}
+
+	user.Disabled = false
+	user.DisabledAt = time.Time{}
+	user.DisabledReason = ""
+
+	return store.SaveUser(*user)
+}
+
+// IsUserDisabled returns true if a local user with the given username exists and is disabled.
+// LDAP users cannot be disabled.
+func IsUserDisabled(username string) (bool, error) {
+	user, err := store.GetUser(username)
+
+	if err != nil || user == nil {
+		return false, err
+	}
+
+	return user.Disabled, nil
+}
+
+// RecordLogin saves the time and the client IP address of a successful login of the user with the given username.
+func RecordLogin(username string, clientIp string) error {
+	user, err := store.GetUser(username)
+
+	if err != nil || user == nil {
+		return err
+	}
+
+	user.LastLogin = time.Now()
+	user.LastLoginIP = clientIp
+
+	return store.SaveUser(*user)
+}
+
+// CheckEmail returns an error if the given email address is not empty and not a plain address like 'foo@example.org'.
+func CheckEmail(email string) error {
+	if email == "" {
 		return nil
-	})
+	}
+
+	if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+		return errors.New("invalid email address '" + email + "'")
+	}
 
-	return user
+	return nil
 }
 
 // GetUserByUsernameCaseInsensitive looks up username (case-insensitive) in the database and returns the User if found.
 // Returns nil if the user was not found.
 func GetUserByUsernameCaseInsensitive(username string) *User {
-	users := GetUsers()
+	users, err := store.GetUsers()
+
+	if err != nil {
+		appLog.Printf("error: could not read users from the database. %s\n", err)
+		return nil
+	}
 
 	for _, user := range users {
 		if user.Username == username {
@@ -123,3 +290,33 @@ func GetUserByUsernameCaseInsensitive(username string) *User {
 
 	return nil
 }
+
+// GetUserGroups returns the groups of the user with the given username. The groups of local users are
+// looked up in the database. If no local user exists, the groups are looked up in LDAP.
+func GetUserGroups(username string) []string {
+	if user, _ := store.GetUser(username); user != nil {
+		return user.Groups
+	}
+
+	return ldapGetUserGroups(username)
+}
+
+// GetUserDisplayName returns the display name of the user with the given username. The display name of local users
+// is looked up in the database. If no local user exists, the display name is looked up in LDAP.
+func GetUserDisplayName(username string) string {
+	if user, _ := store.GetUser(username); user != nil {
+		return user.DisplayName
+	}
+
+	return ldapGetUserDisplayName(username)
+}
+
+// GetUserEmail returns the email address of the user with the given username. The email address of local users is
+// looked up in the database. If no local user exists, the email address is looked up in LDAP.
+func GetUserEmail(username string) string {
+	if user, _ := store.GetUser(username); user != nil {
+		return user.Email
+	}
+
+	return ldapGetUserEmail(username)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateUserGroups(t *testing.T) {
+	previousStore := store
+	store = NewMemoryStore()
+
+	t.Cleanup(func() {
+		store = previousStore
+		resetSigningState()
+		PurgeCookieCache()
+	})
+
+	if err := store.SaveUser(User{Username: "alice", Groups: []string{"users", "admins"}}); err != nil {
+		t.Fatalf("SaveUser: %s", err)
+	}
+
+	session := Cookie{ID: "1", Value: "hash-a1", Username: "alice", Groups: []string{"users", "admins"}, Expires: time.Now().Add(time.Hour)}
+
+	if err := SaveCookie(session); err != nil {
+		t.Fatalf("SaveCookie: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		add     []string
+		remove  []string
+		groups  string
+		changed bool
+	}{
+		{name: "existing group", add: []string{"Users"}, groups: "users,admins", changed: false},
+		{name: "remove group", remove: []string{"ADMINS"}, groups: "users", changed: true},
+		{name: "add group", add: []string{"editors", " editors "}, groups: "users,editors", changed: true},
+		{name: "replace group", add: []string{"viewers"}, remove: []string{"editors"}, groups: "users,viewers", changed: true},
+	}
+
+	for _, test := range tests {
+		changed, err := UpdateUserGroups("alice", test.add, test.remove)
+
+		if err != nil {
+			t.Fatalf("%s: UpdateUserGroups: %s", test.name, err)
+		}
+
+		user, _ := store.GetUser("alice")
+
+		if changed != test.changed || strings.Join(user.Groups, ",") != test.groups {
+			t.Errorf("%s: got %v and groups %v, want %v and groups %s", test.name, changed, user.Groups, test.changed, test.groups)
+		}
+	}
+
+	if sessions, err := store.GetSessionsByUsername("alice"); err != nil || len(sessions) != 0 {
+		t.Errorf("the sessions of the user were not revoked after the groups changed: %+v, %v", sessions, err)
+	}
+
+	if _, err := UpdateUserGroups("bob", []string{"users"}, nil); err == nil {
+		t.Errorf("UpdateUserGroups of a missing user: no error")
+	}
+}
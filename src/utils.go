@@ -2,9 +2,15 @@ package main
 
 import (
 	"embed"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
 // This file contains any helper functions that can be useful in any file/module.
@@ -50,14 +56,130 @@ func GetFilenamesFromFS(fs embed.FS, path string) []string {
 	return filenames
 }
 
+// forwardAuthContextKey is set in the Gin context of requests to the /auth/forward route (see IsForwardAuthRequest).
+const forwardAuthContextKey = "forwardAuth"
+
+// IsForwardAuthRequest returns true if the given Gin context belongs to a request of a forward-auth proxy
+// (Traefik, Caddy). These proxies copy the headers of the client request into the auth request, so only the
+// 'X-Forwarded-*' headers set by the proxy describe the original request.
+func IsForwardAuthRequest(c *gin.Context) bool {
+	return c.GetBool(forwardAuthContextKey)
+}
+
 // GetClientIpFromContext retrieves and returns the real client IP from the given Gin context
-// using the 'X-Original-Remote-Addr' header set by NGINX.
+// using the 'X-Original-Remote-Addr' header set by NGINX. If the header is not set, the first address of the
+// 'X-Forwarded-For' header (set by forward-auth proxies like Traefik and Caddy) is used if the forwarded headers
+// are trusted (see TrustForwardedHeaders). Requests of forward-auth proxies (see IsForwardAuthRequest) only use
+// the 'X-Forwarded-For' header, since the 'X-Original-Remote-Addr' header could be set by the client.
 func GetClientIpFromContext(c *gin.Context) string {
-	clientIp := c.GetHeader("X-Original-Remote-Addr")
+	clientIp := ""
+	forwardAuth := IsForwardAuthRequest(c)
+
+	if !forwardAuth {
+		clientIp = c.GetHeader("X-Original-Remote-Addr")
+	}
+
+	if clientIp == "" && (forwardAuth || TrustForwardedHeaders(c)) {
+		clientIp = strings.TrimSpace(strings.Split(c.GetHeader("X-Forwarded-For"), ",")[0])
+	}
 
 	if clientIp == "" {
-		appLog.Print("warning: could not determine (real) client IP address - 'X-Original-Remote-Addr' header was not set by nginx")
+		appLog.Print("warning: could not determine (real) client IP address - neither 'X-Original-Remote-Addr' nor 'X-Forwarded-For' header was set")
 	}
 
 	return clientIp
 }
+
+// TrustForwardedHeaders returns true if the 'X-Forwarded-*' headers of the given Gin context are honored.
+// If trusted proxies are configured in section [ForwardAuth], the headers are only honored for requests
+// of these proxies. Otherwise, the headers are honored if forward-auth is enabled.
+func TrustForwardedHeaders(c *gin.Context) bool {
+	trustedProxies := GetForwardAuthTrustedProxies()
+
+	if len(trustedProxies) == 0 {
+		return GetForwardAuthEnabled()
+	}
+
+	peer, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+
+	if err != nil {
+		return false
+	}
+
+	peerIp := net.ParseIP(peer)
+
+	for _, trustedProxy := range trustedProxies {
+		if peerIp != nil && trustedProxy.Contains(peerIp) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetOriginalRequestFromContext retrieves and returns the host (without port) and the path of the original
+// request from the given Gin context using the 'X-Original-Host' and 'X-Original-URI' headers set by NGINX.
+// Forward-auth proxies (Traefik, Caddy) set the 'X-Forwarded-Host' and 'X-Forwarded-Uri' headers instead, which are
+// only used if the forwarded headers are trusted (see TrustForwardedHeaders). Requests of forward-auth proxies
+// (see IsForwardAuthRequest) only use the 'X-Forwarded-*' headers, since the 'X-Original-*' headers could be set
+// by the client.
+// If none of the host headers is set, the 'Host' header of the request is used.
+// The returned path is decoded and cleaned (see CleanRequestPath). If none of the URI headers is set or the URI
+// could not be parsed, the path '/' and an error are returned.
+func GetOriginalRequestFromContext(c *gin.Context) (host string, path string, err error) {
+	forwardAuth := IsForwardAuthRequest(c)
+	trustForwarded := forwardAuth || TrustForwardedHeaders(c)
+
+	if !forwardAuth {
+		host = c.GetHeader("X-Original-Host")
+	}
+
+	if host == "" && trustForwarded {
+		host = c.GetHeader("X-Forwarded-Host")
+	}
+
+	if host == "" {
+		host = c.Request.Host
+	}
+
+	if strings.Contains(host, ":") {
+		if hostWithoutPort, _, err := net.SplitHostPort(host); err == nil {
+			host = hostWithoutPort
+		}
+	}
+
+	originalUri := ""
+
+	if !forwardAuth {
+		originalUri = c.GetHeader("X-Original-URI")
+	}
+
+	if originalUri == "" && trustForwarded {
+		originalUri = c.GetHeader("X-Forwarded-Uri")
+	}
+
+	if originalUri == "" {
+		return host, "/", errors.New("the original URI is not set ('X-Original-URI' or 'X-Forwarded-Uri' header)")
+	}
+
+	parsedUri, err := url.ParseRequestURI(originalUri)
+
+	if err != nil {
+		return host, "/", fmt.Errorf("could not parse the original URI '%s': %s", originalUri, err)
+	}
+
+	return host, CleanRequestPath(parsedUri.Path), nil
+}
+
+// CleanRequestPath returns the shortest equivalent of the given (decoded) request path with a leading '/',
+// so that dot segments like '/public/../admin' cannot be used to match authorization rules of other paths.
+// A trailing '/' is preserved.
+func CleanRequestPath(requestPath string) string {
+	cleanPath := path.Clean("/" + requestPath)
+
+	if strings.HasSuffix(requestPath, "/") && cleanPath != "/" {
+		cleanPath += "/"
+	}
+
+	return cleanPath
+}